@@ -0,0 +1,228 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrUserNotFound - 表示用户不存在
+	ErrUserNotFound = errors.New("user isn't found")
+
+	// ErrPasswordIncorrect - 表示密码不正确
+	ErrPasswordIncorrect = errors.New("password is incorrect")
+
+	// ErrUserDisabled - 表示用户已被禁用
+	ErrUserDisabled = errors.New("user is disabled")
+
+	// ErrUserLocked - 表示用户已被锁定
+	ErrUserLocked = errors.New("user is locked")
+
+	// ErrUserNotActive - 表示用户尚未激活或已过期
+	ErrUserNotActive = errors.New("user isn't active")
+)
+
+var (
+	// MaxLoginFailures 连续登录失败多少次后锁定用户, 小于等于 0 时不锁定
+	MaxLoginFailures int64 = 5
+
+	// LockoutDuration 用户被锁定的时长
+	LockoutDuration = 30 * time.Minute
+)
+
+// AuthenticateContext 校验用户名和密码, 成功时返回该用户, ip 为客户端的地址,
+// 登录成功时记录在用户的 LastLoginIP 中, 可以为空。
+//
+// 当用户被要求修改密码或者密码已过期时, 返回的用户的 MustChangePassword 为 true,
+// 调用者应引导用户先修改密码。
+//
+// 当用户启用了两步验证时返回 ErrSecondFactorRequired, 调用者应让用户输入动态口令,
+// 再调用 AuthenticateWithCode 完成登录。
+func (s *Store) AuthenticateContext(ctx context.Context, db Executor, name, password, ip string) (*User, error) {
+	return s.authenticate(ctx, db, name, password, ip, s.requireSecondFactor(ctx, db))
+}
+
+// AuthenticateContext 使用 DefaultStore, 见 Store.AuthenticateContext
+func AuthenticateContext(ctx context.Context, db Executor, name, password, ip string) (*User, error) {
+	return DefaultStore.AuthenticateContext(ctx, db, name, password, ip)
+}
+
+// Authenticate 使用 context.Background() 调用 AuthenticateContext
+func Authenticate(db Executor, name, password string) (*User, error) {
+	return AuthenticateContext(context.Background(), db, name, password, "")
+}
+
+// AuthenticateFrom 与 Authenticate 相同, ip 为客户端的地址
+func AuthenticateFrom(db Executor, name, password, ip string) (*User, error) {
+	return AuthenticateContext(context.Background(), db, name, password, ip)
+}
+
+// AuthenticateWithCodeContext 校验用户名, 密码和动态口令(或恢复码), 成功时返回该用户,
+// 用户没有启用两步验证时忽略 code。ip 为客户端的地址, 可以为空。
+func (s *Store) AuthenticateWithCodeContext(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	return s.authenticate(ctx, db, name, password, ip, s.verifySecondFactor(ctx, db, code))
+}
+
+// AuthenticateWithCodeContext 使用 DefaultStore, 见 Store.AuthenticateWithCodeContext
+func AuthenticateWithCodeContext(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	return DefaultStore.AuthenticateWithCodeContext(ctx, db, name, password, code, ip)
+}
+
+// AuthenticateWithCode 使用 context.Background() 调用 AuthenticateWithCodeContext
+func AuthenticateWithCode(db Executor, name, password, code string) (*User, error) {
+	return AuthenticateWithCodeContext(context.Background(), db, name, password, code, "")
+}
+
+// AuthenticateWithCodeFrom 与 AuthenticateWithCode 相同, ip 为客户端的地址
+func AuthenticateWithCodeFrom(db Executor, name, password, code, ip string) (*User, error) {
+	return AuthenticateWithCodeContext(context.Background(), db, name, password, code, ip)
+}
+
+func (s *Store) authenticate(ctx context.Context, db Executor, name, password, ip string, secondFactor func(user *User) error) (*User, error) {
+	user, err := s.Users.FindByNameContext(ctx, db, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := checkLoginAllowed(user, now); err != nil {
+		return nil, err
+	}
+
+	if !checkPassword(user.Password, password) {
+		if err := s.Users.loginFailed(ctx, db, user.ID, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrPasswordIncorrect
+	}
+
+	if err := secondFactor(user); err != nil {
+		return nil, err
+	}
+
+	if err := s.Users.recordLogin(ctx, db, user, now, ip); err != nil {
+		return nil, err
+	}
+	if passwordExpired(user, now) {
+		user.MustChangePassword = true
+	}
+	return user, nil
+}
+
+// requireSecondFactor 返回的函数在用户启用了两步验证时返回 ErrSecondFactorRequired
+func (s *Store) requireSecondFactor(ctx context.Context, db Executor) func(user *User) error {
+	return func(user *User) error {
+		enabled, err := s.Users.isTOTPEnabled(ctx, db, user.ID)
+		if err != nil {
+			return err
+		}
+		if enabled {
+			return ErrSecondFactorRequired
+		}
+		return nil
+	}
+}
+
+// verifySecondFactor 返回的函数在用户启用了两步验证时校验 code, 不正确时计入登录失败次数
+func (s *Store) verifySecondFactor(ctx context.Context, db Executor, code string) func(user *User) error {
+	return func(user *User) error {
+		err := s.Users.VerifyTOTPContext(ctx, db, user.ID, code)
+		if err == ErrTOTPNotEnrolled {
+			return nil
+		}
+		if err == ErrTOTPCodeIncorrect {
+			if e := s.Users.loginFailed(ctx, db, user.ID, time.Now()); e != nil {
+				return e
+			}
+		}
+		return err
+	}
+}
+
+// secondFactor 在 code 为空时使用 requireSecondFactor, 否则使用 verifySecondFactor
+func (s *Store) secondFactor(ctx context.Context, db Executor, code string) func(user *User) error {
+	if code == "" {
+		return s.requireSecondFactor(ctx, db)
+	}
+	return s.verifySecondFactor(ctx, db, code)
+}
+
+// checkLoginAllowed 检查用户的状态是否允许登录, 以及用户是否还在锁定期内
+func checkLoginAllowed(user *User, now time.Time) error {
+	if err := userStateError(user.State); err != nil {
+		return err
+	}
+	if now.Before(user.LockedUntil) {
+		return ErrUserLocked
+	}
+	return nil
+}
+
+// loginFailed 累加登录失败次数, 达到 MaxLoginFailures 时锁定用户并清零计数,
+// 计数和锁定都在一条语句中完成, 多个实例同时操作时也不会丢失计数。
+func (self *users) loginFailed(ctx context.Context, db Executor, userID int64, now time.Time) error {
+	if MaxLoginFailures <= 0 {
+		return nil
+	}
+
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET" +
+		" locked_until = CASE WHEN failed_attempts + 1 >= ? THEN ? ELSE locked_until END," +
+		" failed_attempts = CASE WHEN failed_attempts + 1 >= ? THEN 0 ELSE failed_attempts + 1 END" +
+		" WHERE id = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, updateString,
+		MaxLoginFailures,
+		now.Add(LockoutDuration),
+		MaxLoginFailures,
+		userID)
+	return err
+}
+
+func (self *users) loginSucceeded(ctx context.Context, db Executor, userID int64) error {
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET failed_attempts = 0, locked_until = NULL WHERE id = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, updateString, userID)
+	return err
+}
+
+// RecordLoginContext 记录用户登录成功的时间和客户端的地址, 并清除登录失败的计数,
+// 用于调用者自己完成校验(例如 ChainAuthenticator)后补充客户端的地址。
+func (self *users) RecordLoginContext(ctx context.Context, db Executor, userID int64, ip string) error {
+	return self.recordLogin(ctx, db, &User{ID: userID}, time.Now(), ip)
+}
+
+// RecordLogin 使用 context.Background() 调用 RecordLoginContext
+func (self *users) RecordLogin(db Executor, userID int64, ip string) error {
+	return self.RecordLoginContext(context.Background(), db, userID, ip)
+}
+
+func (self *users) recordLogin(ctx context.Context, db Executor, user *User, now time.Time, ip string) error {
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET failed_attempts = 0, locked_until = NULL, last_login_at = ?, last_login_ip = ? WHERE id = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, updateString, now, ip, user.ID)
+	if err != nil {
+		return err
+	}
+	user.FailedAttempts = 0
+	user.LockedUntil = time.Time{}
+	user.LastLoginAt = now
+	user.LastLoginIP = ip
+	return nil
+}
@@ -0,0 +1,98 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		oldMax, oldDuration := MaxLoginFailures, LockoutDuration
+		defer func() {
+			MaxLoginFailures, LockoutDuration = oldMax, oldDuration
+		}()
+		MaxLoginFailures = 3
+		LockoutDuration = time.Hour
+
+		user1 := &User{
+			Name:     "auth1",
+			Password: "auth1_pwd",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		user, err := Authenticate(db, "auth1", "auth1_pwd")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.ID != user1.ID {
+			t.Error(user.ID, user1.ID)
+		}
+
+		if _, err := Authenticate(db, "not_exists", "auth1_pwd"); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+
+		for i := int64(1); i < MaxLoginFailures; i++ {
+			if _, err := Authenticate(db, "auth1", "bad"); err != ErrPasswordIncorrect {
+				t.Error("want ErrPasswordIncorrect, got", err)
+			}
+			user, err := Users.FindByID(db, user1.ID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if user.FailedAttempts != i {
+				t.Error(user.FailedAttempts, i)
+			}
+		}
+
+		// 成功登录后清零计数
+		if _, err := Authenticate(db, "auth1", "auth1_pwd"); err != nil {
+			t.Error(err)
+			return
+		}
+		user, err = Users.FindByID(db, user1.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.FailedAttempts != 0 {
+			t.Error(user.FailedAttempts)
+		}
+
+		for i := int64(0); i < MaxLoginFailures; i++ {
+			if _, err := Authenticate(db, "auth1", "bad"); err != ErrPasswordIncorrect {
+				t.Error("want ErrPasswordIncorrect, got", err)
+			}
+		}
+		if _, err := Authenticate(db, "auth1", "auth1_pwd"); err != ErrUserLocked {
+			t.Error("want ErrUserLocked, got", err)
+		}
+
+		// 锁定过期后可以再次登录
+		LockoutDuration = -time.Second
+		for i := int64(0); i < MaxLoginFailures; i++ {
+			if err := Users.loginFailed(context.Background(), db, user1.ID, time.Now()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if _, err := Authenticate(db, "auth1", "auth1_pwd"); err != nil {
+			t.Error(err)
+		}
+
+		if err := Users.Disable(db, user1.ID, "admin", "left"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "auth1", "auth1_pwd"); err != ErrUserDisabled {
+			t.Error("want ErrUserDisabled, got", err)
+		}
+	})
+}
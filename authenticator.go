@@ -0,0 +1,245 @@
+package permissions
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator 校验用户名和密码, 成功时返回 tpt_users 中对应的用户,
+// 用户的角色总是来自 tpt_roles。
+//
+// code 为两步验证的动态口令(或恢复码), 用户启用了两步验证而 code 为空时应返回
+// ErrSecondFactorRequired, 调用者让用户输入动态口令后带上 code 再校验一次。
+// ip 为客户端的地址, 登录成功时记录在用户的 LastLoginIP 中, 可以为空。
+//
+// 用户名或密码不正确时应返回 ErrUserNotFound 或 ErrPasswordIncorrect,
+// ChainAuthenticator 遇到这两个错误时会继续尝试下一个 Authenticator。
+type Authenticator interface {
+	Authenticate(ctx context.Context, db Executor, name, password, code, ip string) (*User, error)
+}
+
+// AuthenticatorFunc 将一个函数转换为 Authenticator
+type AuthenticatorFunc func(ctx context.Context, db Executor, name, password, code, ip string) (*User, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	return f(ctx, db, name, password, code, ip)
+}
+
+// DBAuthenticator 使用 DefaultStore 的 DBAuthenticator, 见 Store.DBAuthenticator
+var DBAuthenticator = DefaultStore.DBAuthenticator()
+
+// DBAuthenticator 返回使用 s 中 tpt_users 的密码进行校验的 Authenticator,
+// code 为空时见 Store.AuthenticateContext, 否则见 Store.AuthenticateWithCodeContext
+func (s *Store) DBAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+		if code == "" {
+			return s.AuthenticateContext(ctx, db, name, password, ip)
+		}
+		return s.AuthenticateWithCodeContext(ctx, db, name, password, code, ip)
+	})
+}
+
+// ChainAuthenticator 依次尝试每个 Authenticator, 直到有一个成功为止,
+// 遇到 ErrUserNotFound 和 ErrPasswordIncorrect 以外的错误时立即返回。
+type ChainAuthenticator []Authenticator
+
+func (chain ChainAuthenticator) Authenticate(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	lastErr := ErrUserNotFound
+	for _, authenticator := range chain {
+		user, err := authenticator.Authenticate(ctx, db, name, password, code, ip)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrUserNotFound && err != ErrPasswordIncorrect {
+			return nil, err
+		}
+		if err == ErrPasswordIncorrect {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// provisionUser 在外部系统校验通过后查找 tpt_users 中对应的用户,
+// 用户不存在并且 autoProvision 为 true 时自动创建它。
+// 用户的状态和两步验证与 AuthenticateWithCodeContext 一样检查, code 为空时见 AuthenticateContext。
+func (s *Store) provisionUser(ctx context.Context, db Executor, name, code, ip string, autoProvision bool) (*User, error) {
+	user, err := s.Users.FindByNameContext(ctx, db, name)
+	if err == sql.ErrNoRows {
+		if !autoProvision {
+			return nil, ErrUserNotFound
+		}
+
+		user = &User{
+			Name:        name,
+			Description: "auto provisioned",
+			State:       UserActive,
+		}
+		_, err = s.Users.CreateItContext(ctx, db, user)
+		if err != nil {
+			// 可能是另一个实例同时创建了它, 没有找到时返回创建时的错误
+			createErr := err
+			user, err = s.Users.FindByNameContext(ctx, db, name)
+			if err == sql.ErrNoRows {
+				return nil, createErr
+			}
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := checkLoginAllowed(user, now); err != nil {
+		return nil, err
+	}
+	if err := s.secondFactor(ctx, db, code)(user); err != nil {
+		return nil, err
+	}
+	if err := s.Users.recordLogin(ctx, db, user, now, ip); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func storeOrDefault(s *Store) *Store {
+	if s == nil {
+		return DefaultStore
+	}
+	return s
+}
+
+// LDAPAuthenticator 用用户名和密码绑定(bind)到 LDAP 服务器进行校验
+type LDAPAuthenticator struct {
+	// URL LDAP 服务器的地址, 例如 ldap://127.0.0.1:389 或 ldaps://ldap.example.com
+	URL string
+	// BindDN 用户 DN 的模板, 其中的 {username} 会被替换为转义后的用户名,
+	// 例如 uid={username},ou=people,dc=example,dc=com
+	BindDN string
+	// TLSConfig 连接 ldaps:// 时使用的 TLS 配置
+	TLSConfig *tls.Config
+	// Timeout 连接超时时间
+	Timeout time.Duration
+	// AutoProvision 为 true 时首次登录成功会自动在 tpt_users 中创建用户
+	AutoProvision bool
+	// Store 查找和创建用户时使用的 Store, 为 nil 时使用 DefaultStore
+	Store *Store
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	// 空密码在 LDAP 中是匿名绑定, 总是会成功
+	if name == "" || password == "" {
+		return nil, ErrPasswordIncorrect
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// ldap 包不支持 context, 所以只将 ctx 的截止时间用于连接和请求的超时
+	dialer := &net.Dialer{Timeout: a.Timeout}
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		dialer.Deadline = deadline
+	}
+	opts := []ldap.DialOpt{ldap.DialWithDialer(dialer)}
+	if a.TLSConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(a.TLSConfig))
+	}
+	conn, err := ldap.DialURL(a.URL, opts...)
+	if err != nil {
+		return nil, errors.New("connect to ldap server fail, " + err.Error())
+	}
+	defer conn.Close()
+	if hasDeadline {
+		conn.SetTimeout(time.Until(deadline))
+	}
+
+	dn := strings.Replace(a.BindDN, "{username}", ldap.EscapeDN(name), -1)
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrPasswordIncorrect
+		}
+		return nil, errors.New("bind to ldap server fail, " + err.Error())
+	}
+
+	return storeOrDefault(a.Store).provisionUser(ctx, db, name, code, ip, a.AutoProvision)
+}
+
+// FileAuthenticator 用 htpasswd 格式的文件进行校验, 每行为 "用户名:密码",
+// 密码支持 bcrypt(htpasswd -B) 和 {SHA}(htpasswd -s) 两种格式。
+// 每次校验时都会重新读取文件, 修改文件后不需要重启。
+type FileAuthenticator struct {
+	// Path 文件的路径
+	Path string
+	// AutoProvision 为 true 时首次登录成功会自动在 tpt_users 中创建用户
+	AutoProvision bool
+	// Store 查找和创建用户时使用的 Store, 为 nil 时使用 DefaultStore
+	Store *Store
+}
+
+func (a *FileAuthenticator) Authenticate(ctx context.Context, db Executor, name, password, code, ip string) (*User, error) {
+	hash, err := a.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !checkHtpasswd(hash, password) {
+		return nil, ErrPasswordIncorrect
+	}
+	return storeOrDefault(a.Store).provisionUser(ctx, db, name, code, ip, a.AutoProvision)
+}
+
+func (a *FileAuthenticator) lookup(name string) (string, error) {
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.IndexByte(line, ':')
+		if idx < 0 {
+			continue
+		}
+		if line[:idx] == name {
+			return line[idx+1:], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrUserNotFound
+}
+
+func checkHtpasswd(hash, password string) bool {
+	switch {
+	case isPasswordHash(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(expected)) == 1
+	default:
+		return false
+	}
+}
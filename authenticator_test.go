@@ -0,0 +1,271 @@
+package permissions
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeLDAPServer 是一个只支持简单绑定(simple bind)的 LDAP 服务器, 用于测试
+type fakeLDAPServer struct {
+	listener  net.Listener
+	passwords map[string]string
+}
+
+func startFakeLDAPServer(t *testing.T, passwords map[string]string) *fakeLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeLDAPServer{listener: listener, passwords: passwords}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeLDAPServer) URL() string {
+	return "ldap://" + srv.listener.Addr().String()
+}
+
+func (srv *fakeLDAPServer) Close() error {
+	return srv.listener.Close()
+}
+
+func (srv *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		if op.ClassType != ber.ClassApplication || op.Tag != 0 { // 只处理 BindRequest
+			return
+		}
+
+		dn := op.Children[1].Data.String()
+		password := op.Children[2].Data.String()
+		resultCode := int64(49) // invalidCredentials
+		if expected, ok := srv.passwords[dn]; ok && expected == password {
+			resultCode = 0
+		}
+
+		response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+		response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+		bindResponse := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 1, nil, "Bind Response")
+		bindResponse.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "resultCode"))
+		bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+		bindResponse.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+		response.AppendChild(bindResponse)
+		if _, err := conn.Write(response.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	srv := startFakeLDAPServer(t, map[string]string{
+		"uid=ldap1,ou=people,dc=example,dc=com": "ldap1_pwd",
+		"uid=ldap2,ou=people,dc=example,dc=com": "ldap2_pwd",
+	})
+	defer srv.Close()
+
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		authenticator := &LDAPAuthenticator{
+			URL:           srv.URL(),
+			BindDN:        "uid={username},ou=people,dc=example,dc=com",
+			AutoProvision: true,
+		}
+
+		if _, err := authenticator.Authenticate(ctx, db, "ldap1", "bad", "", ""); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "ldap1", "", "", ""); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+
+		user, err := authenticator.Authenticate(ctx, db, "ldap1", "ldap1_pwd", "", "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.ID == 0 || user.Name != "ldap1" {
+			t.Error(user)
+		}
+
+		// 第二次登录时使用已创建的用户
+		user2, err := authenticator.Authenticate(ctx, db, "ldap1", "ldap1_pwd", "", "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user2.ID != user.ID {
+			t.Error(user2.ID, user.ID)
+		}
+
+		authenticator.AutoProvision = false
+		if _, err := authenticator.Authenticate(ctx, db, "ldap2", "ldap2_pwd", "", ""); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+
+		if err := Users.Disable(db, user.ID, "admin", "left"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "ldap1", "ldap1_pwd", "", ""); err != ErrUserDisabled {
+			t.Error("want ErrUserDisabled, got", err)
+		}
+	})
+}
+
+func TestFileAuthenticator(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("file1_pwd"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte("file2_pwd"))
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(path, []byte("# users\n"+
+		"file1:"+string(bcryptHash)+"\n"+
+		"file2:{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		authenticator := &FileAuthenticator{
+			Path:          path,
+			AutoProvision: true,
+		}
+		ids := map[string]int64{}
+		for _, name := range []string{"file1", "file2"} {
+			user, err := authenticator.Authenticate(ctx, db, name, name+"_pwd", "", "10.0.0.1")
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if user.Name != name || user.LastLoginIP != "10.0.0.1" {
+				t.Error(user.Name, name, user.LastLoginIP)
+			}
+			ids[name] = user.ID
+			if _, err := authenticator.Authenticate(ctx, db, name, "bad", "", ""); err != ErrPasswordIncorrect {
+				t.Error("want ErrPasswordIncorrect, got", err)
+			}
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file3", "file3_pwd", "", ""); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+
+		// 外部校验通过后同样要求两步验证
+		enrollment, err := Users.EnrollTOTP(db, ids["file2"], "tpt")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		code, err := GenerateTOTPCode(enrollment.Secret, time.Now())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.ConfirmTOTP(db, ids["file2"], code); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file2", "file2_pwd", "", ""); err != ErrSecondFactorRequired {
+			t.Error("want ErrSecondFactorRequired, got", err)
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file2", "file2_pwd", "000000x", ""); err != ErrTOTPCodeIncorrect {
+			t.Error("want ErrTOTPCodeIncorrect, got", err)
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file2", "file2_pwd", enrollment.RecoveryCodes[0], ""); err != nil {
+			t.Error(err)
+		}
+
+		// 外部校验通过后同样要检查锁定
+		if err := DefaultStore.execWith(ctx, db, "UPDATE tpt_users SET locked_until = ? WHERE id = ?", time.Now().Add(time.Hour), ids["file1"]); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file1", "file1_pwd", "", ""); err != ErrUserLocked {
+			t.Error("want ErrUserLocked, got", err)
+		}
+
+		// 用户名被已删除的用户占用时创建失败, 返回创建时的错误
+		if err := Users.DeleteByID(db, ids["file2"]); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := authenticator.Authenticate(ctx, db, "file2", "file2_pwd", "", ""); err == nil || err == ErrUserNotFound {
+			t.Error("want the create error, got", err)
+		} else if _, ok := err.(*DuplicateError); !ok {
+			t.Error("want DuplicateError, got", err)
+		}
+	})
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	srv := startFakeLDAPServer(t, map[string]string{
+		"uid=chain1,ou=people,dc=example,dc=com": "chain1_ldap",
+	})
+	defer srv.Close()
+
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		user2 := &User{
+			Name:     "chain2",
+			Password: "chain2_pwd",
+		}
+		if _, err := user2.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		chain := ChainAuthenticator{
+			&LDAPAuthenticator{
+				URL:           srv.URL(),
+				BindDN:        "uid={username},ou=people,dc=example,dc=com",
+				AutoProvision: true,
+			},
+			DBAuthenticator,
+		}
+
+		if _, err := chain.Authenticate(ctx, db, "chain1", "chain1_ldap", "", ""); err != nil {
+			t.Error(err)
+		}
+		if _, err := chain.Authenticate(ctx, db, "chain2", "chain2_pwd", "", ""); err != nil {
+			t.Error(err)
+		}
+		if _, err := chain.Authenticate(ctx, db, "chain2", "bad", "", ""); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+		if _, err := chain.Authenticate(ctx, db, "chain3", "bad", "", ""); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := chain.Authenticate(canceled, db, "chain1", "chain1_ldap", "", ""); err != context.Canceled {
+			t.Error("want context.Canceled, got", err)
+		}
+	})
+}
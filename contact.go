@@ -0,0 +1,169 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// ErrUserAmbiguous - 表示有多个用户匹配同一个邮箱或电话
+var ErrUserAmbiguous = errors.New("more than one user is found")
+
+var (
+	// UniqueEmail 为 true 时 Migrate 会在邮箱上创建唯一索引, 创建和更新用户前也会检查
+	// 邮箱是否已被其他用户使用。为 false 时多个用户可以使用同一个邮箱, 此时按邮箱查找到
+	// 多个用户会返回 ErrUserAmbiguous。打开后需要再次调用 Migrate, 关闭后不会删除已经创建的索引。
+	UniqueEmail bool
+
+	// UniquePhone 与 UniqueEmail 相同, 用于电话
+	UniquePhone bool
+
+	// DefaultPhoneCountryCode 电话号码中没有国家代码时使用的国家代码, 例如 "86",
+	// 为空时不添加国家代码
+	DefaultPhoneCountryCode string
+)
+
+// DuplicateError 表示违反了唯一性约束, Field 是重复的字段
+type DuplicateError struct {
+	Table string
+	Field string
+	Value string
+}
+
+func (e *DuplicateError) Error() string {
+	if e.Value == "" {
+		return "duplicate " + e.Field + " in '" + e.Table + "'"
+	}
+	return "duplicate " + e.Field + " '" + e.Value + "' in '" + e.Table + "'"
+}
+
+// NormalizeEmail 规范化邮箱地址, 去掉首尾的空白并转为小写
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+var phoneFormatChars = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "", "\t", "")
+
+// NormalizePhone 将电话号码规范化为 E.164 的格式, 去掉空格, 横线和括号等分隔符,
+// 将国际前缀 00 替换为 +, 没有国家代码时加上 DefaultPhoneCountryCode。
+func NormalizePhone(phone string) string {
+	phone = phoneFormatChars.Replace(strings.TrimSpace(phone))
+	if phone == "" {
+		return ""
+	}
+	if strings.HasPrefix(phone, "00") {
+		return "+" + phone[2:]
+	}
+	if strings.HasPrefix(phone, "+") || DefaultPhoneCountryCode == "" {
+		return phone
+	}
+	return "+" + DefaultPhoneCountryCode + strings.TrimLeft(phone, "0")
+}
+
+// FindByEmailContext 按邮箱查找用户
+func (self *users) FindByEmailContext(ctx context.Context, db Executor, email string) (*User, error) {
+	return self.findOne(ctx, db, "WHERE email = ?", NormalizeEmail(email))
+}
+
+// FindByEmail 使用 context.Background() 调用 FindByEmailContext
+func (self *users) FindByEmail(db Executor, email string) (*User, error) {
+	return self.FindByEmailContext(context.Background(), db, email)
+}
+
+// FindByPhoneContext 按电话查找用户
+func (self *users) FindByPhoneContext(ctx context.Context, db Executor, phone string) (*User, error) {
+	return self.findOne(ctx, db, "WHERE phone = ?", NormalizePhone(phone))
+}
+
+// FindByPhone 使用 context.Background() 调用 FindByPhoneContext
+func (self *users) FindByPhone(db Executor, phone string) (*User, error) {
+	return self.FindByPhoneContext(context.Background(), db, phone)
+}
+
+// FindByLoginContext 按登录名查找用户, 依次按用户名, 邮箱和电话查找
+func (self *users) FindByLoginContext(ctx context.Context, db Executor, login string) (*User, error) {
+	user, err := self.FindByNameContext(ctx, db, login)
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+	if strings.Contains(login, "@") {
+		user, err = self.FindByEmailContext(ctx, db, login)
+		if err != sql.ErrNoRows {
+			return user, err
+		}
+	}
+	return self.FindByPhoneContext(ctx, db, login)
+}
+
+// FindByLogin 使用 context.Background() 调用 FindByLoginContext
+func (self *users) FindByLogin(db Executor, login string) (*User, error) {
+	return self.FindByLoginContext(context.Background(), db, login)
+}
+
+// findOne 查找唯一的一个用户, 没有找到时返回 sql.ErrNoRows, 找到多个时返回 ErrUserAmbiguous
+func (self *users) findOne(ctx context.Context, db Executor, queryString string, value string) (*User, error) {
+	if value == "" {
+		return nil, sql.ErrNoRows
+	}
+	results, err := self.QueryWithContext(ctx, db, queryString, value)
+	if err != nil {
+		return nil, err
+	}
+	switch len(results) {
+	case 0:
+		return nil, sql.ErrNoRows
+	case 1:
+		return results[0], nil
+	default:
+		return nil, ErrUserAmbiguous
+	}
+}
+
+// checkUnique 按 UniqueEmail 和 UniquePhone 检查邮箱和电话是否已被其他用户使用
+func (self *users) checkUnique(ctx context.Context, db Executor, value *User) error {
+	for _, field := range []struct {
+		enabled bool
+		column  string
+		value   string
+	}{
+		{self.store.uniqueEmail(), "email", value.Email},
+		{self.store.uniquePhone(), "phone", value.Phone},
+	} {
+		if !field.enabled || field.value == "" {
+			continue
+		}
+
+		queryString, err := self.store.rebind("SELECT count(*) FROM " + self.store.TableName("tpt_users") + " WHERE " + field.column + " = ? AND id <> ? AND deleted_at IS NULL")
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := db.QueryRowContext(ctx, queryString, field.value, value.ID).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return &DuplicateError{Table: "tpt_users", Field: field.column, Value: field.value}
+		}
+	}
+	return nil
+}
+
+// toDuplicateError 将数据库返回的违反唯一性约束的错误转换为 DuplicateError,
+// 约束的名称应为 "表名_字段名_uq" 的形式, 其它错误原样返回。
+func toDuplicateError(table string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	field, ok := duplicateKey(err)
+	if !ok {
+		return err
+	}
+	// 表名的前缀可能被 StoreOptions.TablePrefix 修改过, 所以只按不带前缀的表名截取字段名
+	field = strings.TrimSuffix(field, "_uq")
+	if p := strings.Index(field, strings.TrimPrefix(table, "tpt_")+"_"); p >= 0 {
+		field = field[p+len(strings.TrimPrefix(table, "tpt_"))+1:]
+	}
+	return &DuplicateError{Table: table, Field: field}
+}
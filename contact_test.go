@@ -0,0 +1,155 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestNormalizeContact(t *testing.T) {
+	if s := NormalizeEmail(" Tom@Example.COM "); s != "tom@example.com" {
+		t.Error(s)
+	}
+
+	old := DefaultPhoneCountryCode
+	defer func() {
+		DefaultPhoneCountryCode = old
+	}()
+
+	for _, test := range []struct {
+		countryCode string
+		phone       string
+		expected    string
+	}{
+		{"", "", ""},
+		{"", "138 0013-8000", "13800138000"},
+		{"86", "138 0013-8000", "+8613800138000"},
+		{"86", "(010) 6552.9988", "+861065529988"},
+		{"86", "0044 20 7946 0958", "+442079460958"},
+		{"86", "+1 (415) 555-2671", "+14155552671"},
+	} {
+		DefaultPhoneCountryCode = test.countryCode
+		if s := NormalizePhone(test.phone); s != test.expected {
+			t.Error(test.phone, s, test.expected)
+		}
+	}
+}
+
+func TestUserContact(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		oldEmail, oldPhone := UniqueEmail, UniquePhone
+		defer func() {
+			UniqueEmail, UniquePhone = oldEmail, oldPhone
+		}()
+		UniqueEmail, UniquePhone = true, true
+		// dbTest 迁移时选项是关闭的, 打开后再次迁移以创建唯一索引
+		dialect, _ := DialectByName(*driverName)
+		if err := NewStore(db, dialect, nil).Migrate(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+
+		user1 := &User{
+			Name:  "contact1",
+			Email: " Contact1@Example.com",
+			Phone: "+86 138-0013-8000",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if user1.Email != "contact1@example.com" || user1.Phone != "+8613800138000" {
+			t.Error(user1.Email, user1.Phone)
+		}
+
+		for _, login := range []string{"contact1", "CONTACT1@example.com", "+86 13800138000"} {
+			user, err := Users.FindByLogin(db, login)
+			if err != nil {
+				t.Error(login, err)
+				continue
+			}
+			if user.ID != user1.ID {
+				t.Error(login, user.ID, user1.ID)
+			}
+		}
+		if _, err := Users.FindByEmail(db, "CONTACT1@EXAMPLE.COM"); err != nil {
+			t.Error(err)
+		}
+		if _, err := Users.FindByPhone(db, "+86-138-0013-8000"); err != nil {
+			t.Error(err)
+		}
+		if _, err := Users.FindByLogin(db, "contact2@example.com"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		user2 := &User{
+			Name:  "contact2",
+			Email: "contact1@example.com",
+		}
+		_, err := user2.CreateIt(db)
+		if e, ok := err.(*DuplicateError); !ok || e.Field != "email" {
+			t.Error("want DuplicateError, got", err)
+		}
+
+		user2.Email = ""
+		user2.Phone = "+8613800138000"
+		_, err = user2.CreateIt(db)
+		if e, ok := err.(*DuplicateError); !ok || e.Field != "phone" {
+			t.Error("want DuplicateError, got", err)
+		}
+
+		user2.Phone = ""
+		if _, err := user2.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 绕过检查时由数据库的唯一索引保证
+		err = DefaultStore.execWith(context.Background(), db, "UPDATE tpt_users SET email = ? WHERE id = ?", "contact1@example.com", user2.ID)
+		if e, ok := toDuplicateError("tpt_users", err).(*DuplicateError); !ok || e.Field != "email" {
+			t.Error("want DuplicateError, got", err)
+		}
+
+		user3 := &User{
+			Name: "contact1",
+		}
+		_, err = user3.CreateIt(db)
+		if e, ok := err.(*DuplicateError); !ok || e.Field != "name" {
+			t.Error("want DuplicateError, got", err)
+		}
+	})
+}
+
+func TestUserContactNotUnique(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		oldEmail, oldPhone := UniqueEmail, UniquePhone
+		defer func() {
+			UniqueEmail, UniquePhone = oldEmail, oldPhone
+		}()
+		UniqueEmail, UniquePhone = false, false
+
+		for _, name := range []string{"shared1", "shared2"} {
+			user := &User{
+				Name:  name,
+				Email: "shared@example.com",
+				Phone: "+8613900139000",
+			}
+			if _, err := user.CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if _, err := Users.FindByEmail(db, "shared@example.com"); err != ErrUserAmbiguous {
+			t.Error("want ErrUserAmbiguous, got", err)
+		}
+		if _, err := Users.FindByLogin(db, "+8613900139000"); err != ErrUserAmbiguous {
+			t.Error("want ErrUserAmbiguous, got", err)
+		}
+		if user, err := Users.FindByLogin(db, "shared1"); err != nil {
+			t.Error(err)
+		} else if user.Name != "shared1" {
+			t.Error("want shared1, got", user.Name)
+		}
+	})
+}
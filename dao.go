@@ -1,26 +1,96 @@
 package permissions
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
+)
+
+// Executor 是 *sql.DB, *sql.Tx 和 *sql.Conn 共有的方法, 所有的 DAO 方法都接受它,
+// 所以它们既可以直接在数据库上执行, 也可以在事务(见 InTx)中执行。
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	"github.com/lib/pq"
+var (
+	_ Executor = &sql.DB{}
+	_ Executor = &sql.Tx{}
+	_ Executor = &sql.Conn{}
 )
 
-type roles struct{}
+// execWith 格式化占位符后执行一条不返回记录的 SQL 语句
+func (s *Store) execWith(ctx context.Context, db Executor, sqlString string, args ...interface{}) error {
+	sqlString, err := s.rebind(sqlString)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, sqlString, args...)
+	return err
+}
+
+// insertWith 格式化占位符后执行一条 INSERT INTO table(...) VALUES (...) 语句,
+// 并按 Dialect.InsertID() 指定的方式返回新记录的 id
+func (s *Store) insertWith(ctx context.Context, db Executor, sqlString string, args ...interface{}) (int64, error) {
+	var id int64
+	style := s.dialect.InsertID()
+	switch style {
+	case InsertIDReturning:
+		sqlString = sqlString + " RETURNING " + s.dialect.Quote("id")
+	case InsertIDOutput:
+		p := strings.Index(sqlString, ") VALUES")
+		if p < 0 {
+			return 0, errors.New("'" + sqlString + "' isn't a insert statement")
+		}
+		sqlString = sqlString[:p+1] + " OUTPUT INSERTED." + s.dialect.Quote("id") + sqlString[p+1:]
+	case InsertIDReturningInto:
+		sqlString = sqlString + " RETURNING " + s.dialect.Quote("id") + " INTO ?"
+		args = append(args, sql.Out{Dest: &id})
+	}
+
+	sqlString, err := s.rebind(sqlString)
+	if err != nil {
+		return 0, err
+	}
+
+	switch style {
+	case InsertIDReturning, InsertIDOutput:
+		err = db.QueryRowContext(ctx, sqlString, args...).Scan(&id)
+		return id, err
+	case InsertIDReturningInto:
+		_, err = db.ExecContext(ctx, sqlString, args...)
+		return id, err
+	default:
+		result, err := db.ExecContext(ctx, sqlString, args...)
+		if nil != err {
+			return 0, err
+		}
+		return result.LastInsertId()
+	}
+}
+
+type roles struct {
+	store       *Store
+	withDeleted bool
+}
 
 func (self *roles) scan(scanner RowScanner) (*Role, error) {
 	var value Role
 	var nullDescription sql.NullString
 	var nullPermissionKeys sql.NullString
-	var nullCreatedAt pq.NullTime
-	var nullUpdatedAt pq.NullTime
+	var nullDeletedAt sql.NullTime
+	var nullCreatedAt sql.NullTime
+	var nullUpdatedAt sql.NullTime
 
 	e := scanner.Scan(
 		&value.ID,
 		&value.Name,
 		&nullDescription,
 		&nullPermissionKeys,
+		&nullDeletedAt,
 		&nullCreatedAt,
 		&nullUpdatedAt)
 	if nil != e {
@@ -33,6 +103,9 @@ func (self *roles) scan(scanner RowScanner) (*Role, error) {
 	if nullPermissionKeys.Valid {
 		value.PermissionKeys = nullPermissionKeys.String
 	}
+	if nullDeletedAt.Valid {
+		value.DeletedAt = nullDeletedAt.Time
+	}
 	if nullCreatedAt.Valid {
 		value.CreatedAt = nullCreatedAt.Time
 	}
@@ -42,25 +115,42 @@ func (self *roles) scan(scanner RowScanner) (*Role, error) {
 	return &value, nil
 }
 
-const rolePrefix = "select id, name, description, permission_keys, created_at, updated_at from tpt_roles "
+const rolePrefix = "select id, name, description, permission_keys, deleted_at, created_at, updated_at from "
 
-func (self *roles) QueryRowWith(db *sql.DB, queryString string, args ...interface{}) (*Role, error) {
-	queryString, err := PlaceholderFormat(queryString)
+// from 返回查询的表, 默认排除已删除的角色, 表名由 Store.TableName 决定, 但别名总是 tpt_roles,
+// 所以调用者传入的条件中可以继续使用 tpt_roles.xxx 的形式
+func (self *roles) from() string {
+	if self.withDeleted {
+		return self.store.TableName("tpt_roles") + " tpt_roles "
+	}
+	return "(SELECT * FROM " + self.store.TableName("tpt_roles") + " WHERE deleted_at IS NULL) tpt_roles "
+}
+
+// QueryRowWithContext 将 queryString 直接拼接在固定的 SELECT 之后, 不要把未经检查的输入放在 queryString 中,
+// 一般的查询请使用 FindOneContext 和 Filter
+func (self *roles) QueryRowWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) (*Role, error) {
+	queryString, err := self.store.rebind(rolePrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	row := db.QueryRow(rolePrefix+queryString, args...)
+	row := db.QueryRowContext(ctx, queryString, args...)
 	return self.scan(row)
 }
 
-func (self *roles) QueryWith(db *sql.DB, queryString string, args ...interface{}) ([]*Role, error) {
-	queryString, err := PlaceholderFormat(queryString)
+func (self *roles) QueryRowWith(db Executor, queryString string, args ...interface{}) (*Role, error) {
+	return self.QueryRowWithContext(context.Background(), db, queryString, args...)
+}
+
+// QueryWithContext 将 queryString 直接拼接在固定的 SELECT 之后, 不要把未经检查的输入放在 queryString 中,
+// 一般的查询请使用 FindContext 和 Filter
+func (self *roles) QueryWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) ([]*Role, error) {
+	queryString, err := self.store.rebind(rolePrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := db.Query(rolePrefix+queryString, args...)
+	rows, err := db.QueryContext(ctx, queryString, args...)
 	if nil != err {
 		return nil, err
 	}
@@ -77,68 +167,88 @@ func (self *roles) QueryWith(db *sql.DB, queryString string, args ...interface{}
 	return results, rows.Err()
 }
 
-func (self *roles) FindByID(db *sql.DB, id int64) (*Role, error) {
-	return self.QueryRowWith(db, "WHERE id = ?", id)
+func (self *roles) QueryWith(db Executor, queryString string, args ...interface{}) ([]*Role, error) {
+	return self.QueryWithContext(context.Background(), db, queryString, args...)
 }
 
-func (self *roles) FindByName(db *sql.DB, name string) (*Role, error) {
-	return self.QueryRowWith(db, "WHERE name = ?", name)
+func (self *roles) FindByIDContext(ctx context.Context, db Executor, id int64) (*Role, error) {
+	return self.QueryRowWithContext(ctx, db, "WHERE id = ?", id)
 }
 
-func (self *roles) FindByUserID(db *sql.DB, userID int64) ([]*Role, error) {
-	return self.QueryWith(db, "WHERE EXISTS (SELECT * FROM tpt_user_roles WHERE user_id = ? AND tpt_roles.id = tpt_user_roles.role_id)", userID)
+func (self *roles) FindByID(db Executor, id int64) (*Role, error) {
+	return self.FindByIDContext(context.Background(), db, id)
 }
 
-func (self *roles) FindByUserName(db *sql.DB, username string) ([]*Role, error) {
-	return self.QueryWith(db, "WHERE EXISTS (SELECT * FROM tpt_user_roles WHERE tpt_roles.id = tpt_user_roles.role_id AND EXISTS (SELECT * FROM tpt_users WHERE name = ? AND tpt_user_roles.user_id = tpt_users.id))", username)
+func (self *roles) FindByNameContext(ctx context.Context, db Executor, name string) (*Role, error) {
+	return self.QueryRowWithContext(ctx, db, "WHERE name = ?", name)
 }
 
-func (self *roles) CreateIt(db *sql.DB, value *Role) (int64, error) {
-	sqlString := "INSERT INTO tpt_roles(name, description, permission_keys, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
-	sqlString, err := PlaceholderFormat(sqlString)
-	if err != nil {
-		return 0, err
-	}
+func (self *roles) FindByName(db Executor, name string) (*Role, error) {
+	return self.FindByNameContext(context.Background(), db, name)
+}
 
-	now := time.Now()
-	if IsReturning {
-		sqlString = sqlString + " RETURNING \"id\""
+func (self *roles) FindByUserIDContext(ctx context.Context, db Executor, userID int64) ([]*Role, error) {
+	return self.QueryWithContext(ctx, db, "WHERE EXISTS (SELECT * FROM "+self.store.TableName("tpt_user_roles")+" tpt_user_roles WHERE user_id = ? AND tpt_roles.id = tpt_user_roles.role_id)", userID)
+}
 
-		err := db.QueryRow(sqlString,
-			value.Name,
-			value.Description,
-			value.PermissionKeys,
-			now,
-			now).Scan(&value.ID)
-		return value.ID, err
+func (self *roles) FindByUserID(db Executor, userID int64) ([]*Role, error) {
+	return self.FindByUserIDContext(context.Background(), db, userID)
+}
+
+func (self *roles) FindByUserNameContext(ctx context.Context, db Executor, username string) ([]*Role, error) {
+	return self.QueryWithContext(ctx, db, "WHERE EXISTS (SELECT * FROM "+self.store.TableName("tpt_user_roles")+" tpt_user_roles WHERE tpt_roles.id = tpt_user_roles.role_id"+
+		" AND EXISTS (SELECT * FROM "+self.store.TableName("tpt_users")+" tpt_users WHERE name = ? AND deleted_at IS NULL AND tpt_user_roles.user_id = tpt_users.id))", username)
+}
+
+func (self *roles) FindByUserName(db Executor, username string) ([]*Role, error) {
+	return self.FindByUserNameContext(context.Background(), db, username)
+}
+
+func (self *roles) CreateItContext(ctx context.Context, db Executor, value *Role) (int64, error) {
+	if err := self.store.checkDeletedName(ctx, db, "tpt_roles", value.Name, 0); err != nil {
+		return 0, err
 	}
 
-	result, err := db.Exec(sqlString, value.Name, value.Description, value.PermissionKeys, now, now)
+	now := time.Now()
+	id, err := self.store.insertWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_roles")+"(name, description, permission_keys, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		value.Name,
+		value.Description,
+		value.PermissionKeys,
+		now,
+		now)
 	if nil != err {
-		return 0, err
+		return 0, toDuplicateError("tpt_roles", err)
 	}
-	return result.LastInsertId()
+	value.ID = id
+	return id, nil
+}
+
+func (self *roles) CreateIt(db Executor, value *Role) (int64, error) {
+	return self.CreateItContext(context.Background(), db, value)
 }
 
-func (self *roles) UpdateIt(db *sql.DB, value *Role) error {
+func (self *roles) UpdateItContext(ctx context.Context, db Executor, value *Role) error {
 	if 0 == value.ID {
 		return ThrowPrimaryKeyInvalid("tpt_roles")
 	}
+	if err := self.store.checkDeletedName(ctx, db, "tpt_roles", value.Name, value.ID); err != nil {
+		return err
+	}
 
-	updateString := "UPDATE tpt_roles SET name=?, description=?, permission_keys=?, updated_at=? WHERE id = ?"
-	updateString, err := PlaceholderFormat(updateString)
+	updateString := "UPDATE " + self.store.TableName("tpt_roles") + " SET name=?, description=?, permission_keys=?, updated_at=? WHERE id = ? AND deleted_at IS NULL"
+	updateString, err := self.store.rebind(updateString)
 	if err != nil {
 		return err
 	}
 
-	result, err := db.Exec(updateString,
+	result, err := db.ExecContext(ctx, updateString,
 		value.Name,
 		value.Description,
 		value.PermissionKeys,
 		time.Now(),
 		value.ID)
 	if nil != err {
-		return err
+		return toDuplicateError("tpt_roles", err)
 	}
 	rowsAffected, err := result.RowsAffected()
 	if nil != err {
@@ -150,35 +260,32 @@ func (self *roles) UpdateIt(db *sql.DB, value *Role) error {
 	return nil
 }
 
-func (self *roles) DeleteIt(db *sql.DB, value *Role) error {
-	return self.DeleteByID(db, value.ID)
+func (self *roles) UpdateIt(db Executor, value *Role) error {
+	return self.UpdateItContext(context.Background(), db, value)
 }
 
-func (self *roles) DeleteByID(db *sql.DB, key int64) error {
-	if 0 == key {
-		return ThrowPrimaryKeyInvalid("tpt_roles")
-	}
+func (self *roles) DeleteItContext(ctx context.Context, db Executor, value *Role) error {
+	return self.DeleteByIDContext(ctx, db, value.ID)
+}
 
-	deleteString := "DELETE FROM tpt_roles WHERE id = ?"
-	deleteString, err := PlaceholderFormat(deleteString)
-	if err != nil {
-		return err
-	}
-	result, err := db.Exec(deleteString, key)
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return ErrNotDeleted
-	}
-	return nil
+func (self *roles) DeleteIt(db Executor, value *Role) error {
+	return self.DeleteItContext(context.Background(), db, value)
 }
 
-type users struct{}
+// DeleteByIDContext 软删除一条记录, 见 PurgeByID
+func (self *roles) DeleteByIDContext(ctx context.Context, db Executor, key int64) error {
+	return self.store.softDelete(ctx, db, "tpt_roles", key)
+}
+
+// DeleteByID 使用 context.Background() 调用 DeleteByIDContext
+func (self *roles) DeleteByID(db Executor, key int64) error {
+	return self.DeleteByIDContext(context.Background(), db, key)
+}
+
+type users struct {
+	store       *Store
+	withDeleted bool
+}
 
 func (self *users) scan(scanner RowScanner) (*User, error) {
 	var value User
@@ -187,9 +294,16 @@ func (self *users) scan(scanner RowScanner) (*User, error) {
 	var nullPhone sql.NullString
 	var nullEmail sql.NullString
 	var nullState sql.NullInt64
+	var nullFailedAttempts sql.NullInt64
+	var nullLockedUntil sql.NullTime
+	var nullPasswordChangedAt sql.NullTime
+	var nullMustChangePassword sql.NullBool
+	var nullDeletedAt sql.NullTime
+	var nullLastLoginAt sql.NullTime
+	var nullLastLoginIP sql.NullString
 	//var nullAttributes sql.NullString
-	var nullCreatedAt pq.NullTime
-	var nullUpdatedAt pq.NullTime
+	var nullCreatedAt sql.NullTime
+	var nullUpdatedAt sql.NullTime
 
 	e := scanner.Scan(
 		&value.ID,
@@ -199,6 +313,13 @@ func (self *users) scan(scanner RowScanner) (*User, error) {
 		&nullPhone,
 		&nullEmail,
 		&nullState,
+		&nullFailedAttempts,
+		&nullLockedUntil,
+		&nullPasswordChangedAt,
+		&nullMustChangePassword,
+		&nullDeletedAt,
+		&nullLastLoginAt,
+		&nullLastLoginIP,
 		//&nullAttributes,
 		&nullCreatedAt,
 		&nullUpdatedAt)
@@ -221,6 +342,27 @@ func (self *users) scan(scanner RowScanner) (*User, error) {
 	if nullState.Valid {
 		value.State = nullState.Int64
 	}
+	if nullFailedAttempts.Valid {
+		value.FailedAttempts = nullFailedAttempts.Int64
+	}
+	if nullLockedUntil.Valid {
+		value.LockedUntil = nullLockedUntil.Time
+	}
+	if nullPasswordChangedAt.Valid {
+		value.PasswordChangedAt = nullPasswordChangedAt.Time
+	}
+	if nullMustChangePassword.Valid {
+		value.MustChangePassword = nullMustChangePassword.Bool
+	}
+	if nullDeletedAt.Valid {
+		value.DeletedAt = nullDeletedAt.Time
+	}
+	if nullLastLoginAt.Valid {
+		value.LastLoginAt = nullLastLoginAt.Time
+	}
+	if nullLastLoginIP.Valid {
+		value.LastLoginIP = nullLastLoginIP.String
+	}
 	if nullCreatedAt.Valid {
 		value.CreatedAt = nullCreatedAt.Time
 	}
@@ -230,25 +372,42 @@ func (self *users) scan(scanner RowScanner) (*User, error) {
 	return &value, nil
 }
 
-const userPrefix = "select id, name, description, password, phone, email, state, created_at, updated_at from tpt_users "
+const userPrefix = "select id, name, description, password, phone, email, state, failed_attempts, locked_until, password_changed_at, must_change_password, deleted_at, last_login_at, last_login_ip, created_at, updated_at from "
+
+// from 返回查询的表, 默认排除已删除的用户, 表名由 Store.TableName 决定, 但别名总是 tpt_users,
+// 所以调用者传入的条件中可以继续使用 tpt_users.xxx 的形式
+func (self *users) from() string {
+	if self.withDeleted {
+		return self.store.TableName("tpt_users") + " tpt_users "
+	}
+	return "(SELECT * FROM " + self.store.TableName("tpt_users") + " WHERE deleted_at IS NULL) tpt_users "
+}
 
-func (self *users) QueryRowWith(db *sql.DB, queryString string, args ...interface{}) (*User, error) {
-	queryString, err := PlaceholderFormat(queryString)
+// QueryRowWithContext 将 queryString 直接拼接在固定的 SELECT 之后, 不要把未经检查的输入放在 queryString 中,
+// 一般的查询请使用 FindOneContext 和 Filter
+func (self *users) QueryRowWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) (*User, error) {
+	queryString, err := self.store.rebind(userPrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	row := db.QueryRow(userPrefix+queryString, args...)
+	row := db.QueryRowContext(ctx, queryString, args...)
 	return self.scan(row)
 }
 
-func (self *users) QueryWith(db *sql.DB, queryString string, args ...interface{}) ([]*User, error) {
-	queryString, err := PlaceholderFormat(queryString)
+func (self *users) QueryRowWith(db Executor, queryString string, args ...interface{}) (*User, error) {
+	return self.QueryRowWithContext(context.Background(), db, queryString, args...)
+}
+
+// QueryWithContext 将 queryString 直接拼接在固定的 SELECT 之后, 不要把未经检查的输入放在 queryString 中,
+// 一般的查询请使用 FindContext 和 Filter
+func (self *users) QueryWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) ([]*User, error) {
+	queryString, err := self.store.rebind(userPrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := db.Query(userPrefix+queryString, args...)
+	rows, err := db.QueryContext(ctx, queryString, args...)
 	if nil != err {
 		return nil, err
 	}
@@ -265,22 +424,34 @@ func (self *users) QueryWith(db *sql.DB, queryString string, args ...interface{}
 	return results, rows.Err()
 }
 
-func (self *users) FindByID(db *sql.DB, id int64) (*User, error) {
-	return self.QueryRowWith(db, "WHERE id = ?", id)
+func (self *users) QueryWith(db Executor, queryString string, args ...interface{}) ([]*User, error) {
+	return self.QueryWithContext(context.Background(), db, queryString, args...)
+}
+
+func (self *users) FindByIDContext(ctx context.Context, db Executor, id int64) (*User, error) {
+	return self.QueryRowWithContext(ctx, db, "WHERE id = ?", id)
 }
 
-func (self *users) FindByName(db *sql.DB, name string) (*User, error) {
-	return self.QueryRowWith(db, "WHERE name = ?", name)
+func (self *users) FindByID(db Executor, id int64) (*User, error) {
+	return self.FindByIDContext(context.Background(), db, id)
 }
 
-func (self *users) AddRole(db *sql.DB, userID, roleID int64) error {
-	insertString := "INSERT INTO tpt_user_roles(user_id, role_id, created_at, updated_at) VALUES (?, ?, ?, ?)"
-	insertString, err := PlaceholderFormat(insertString)
+func (self *users) FindByNameContext(ctx context.Context, db Executor, name string) (*User, error) {
+	return self.QueryRowWithContext(ctx, db, "WHERE name = ?", name)
+}
+
+func (self *users) FindByName(db Executor, name string) (*User, error) {
+	return self.FindByNameContext(context.Background(), db, name)
+}
+
+func (self *users) AddRoleContext(ctx context.Context, db Executor, userID, roleID int64) error {
+	insertString := "INSERT INTO " + self.store.TableName("tpt_user_roles") + "(user_id, role_id, created_at, updated_at) VALUES (?, ?, ?, ?)"
+	insertString, err := self.store.rebind(insertString)
 	if err != nil {
 		return err
 	}
 	now := time.Now()
-	_, err = db.Exec(insertString,
+	_, err = db.ExecContext(ctx, insertString,
 		userID,
 		roleID,
 		now,
@@ -288,143 +459,250 @@ func (self *users) AddRole(db *sql.DB, userID, roleID int64) error {
 	return err
 }
 
-func (self *users) RemoveRole(db *sql.DB, userID, roleID int64) error {
-	deleteString := "DELETE FROM tpt_user_roles WHERE user_id = ? AND role_id = ?"
-	deleteString, err := PlaceholderFormat(deleteString)
+func (self *users) AddRole(db Executor, userID, roleID int64) error {
+	return self.AddRoleContext(context.Background(), db, userID, roleID)
+}
+
+func (self *users) RemoveRoleContext(ctx context.Context, db Executor, userID, roleID int64) error {
+	deleteString := "DELETE FROM " + self.store.TableName("tpt_user_roles") + " WHERE user_id = ? AND role_id = ?"
+	deleteString, err := self.store.rebind(deleteString)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec(deleteString,
+	_, err = db.ExecContext(ctx, deleteString,
 		userID,
 		roleID)
 	return err
 }
 
-func (self *users) ListRoles(db *sql.DB, userID int64) ([]*Role, error) {
-	return Roles.FindByUserID(db, userID)
+func (self *users) RemoveRole(db Executor, userID, roleID int64) error {
+	return self.RemoveRoleContext(context.Background(), db, userID, roleID)
 }
 
-func (self *users) CreateIt(db *sql.DB, value *User) (int64, error) {
-	sqlString := "INSERT INTO tpt_users(name, description, password, phone, email, state, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
-	sqlString, err := PlaceholderFormat(sqlString)
-	if err != nil {
+func (self *users) ListRolesContext(ctx context.Context, db Executor, userID int64) ([]*Role, error) {
+	return self.store.Roles.FindByUserIDContext(ctx, db, userID)
+}
+
+func (self *users) ListRoles(db Executor, userID int64) ([]*Role, error) {
+	return self.ListRolesContext(context.Background(), db, userID)
+}
+
+// CreateItContext 创建用户, 密码会按密码策略检查后加密保存, value.Password 会被替换为加密后的密码
+func (self *users) CreateItContext(ctx context.Context, db Executor, value *User) (int64, error) {
+	value.Email = NormalizeEmail(value.Email)
+	value.Phone = NormalizePhone(value.Phone)
+	if err := self.store.checkDeletedName(ctx, db, "tpt_users", value.Name, 0); err != nil {
+		return 0, err
+	}
+	if err := self.checkUnique(ctx, db, value); err != nil {
 		return 0, err
 	}
 
+	password := value.Password
+	if password != "" {
+		hash, err := self.hashNewPassword(ctx, db, 0, password)
+		if err != nil {
+			return 0, err
+		}
+		password = hash
+	}
+
 	now := time.Now()
-	if IsReturning {
-		sqlString = sqlString + " RETURNING \"id\""
-		err := db.QueryRow(sqlString,
+	var passwordChangedAt sql.NullTime
+	if password != "" {
+		passwordChangedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	// 用户和历史密码在同一个事务中创建, 以免用户有了密码却没有历史记录
+	var id int64
+	err := inTx(ctx, db, func(db Executor) error {
+		var err error
+		id, err = self.store.insertWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_users")+"(name, description, password, phone, email, state, password_changed_at, must_change_password, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 			value.Name,
 			value.Description,
-			value.Password,
+			password,
 			value.Phone,
 			value.Email,
 			value.State,
+			passwordChangedAt,
+			value.MustChangePassword,
 			now,
-			now).Scan(&value.ID)
-
-		return value.ID, err
-	}
-
-	result, err := db.Exec(sqlString,
-		value.Name,
-		value.Description,
-		value.Password,
-		value.Phone,
-		value.Email,
-		value.State,
-		now,
-		now)
-	if nil != err {
+			now)
+		if nil != err {
+			return toDuplicateError("tpt_users", err)
+		}
+		if password != "" {
+			return self.addPasswordHistory(ctx, db, id, password, now)
+		}
+		return nil
+	})
+	if err != nil {
 		return 0, err
 	}
-	return result.LastInsertId()
+
+	value.ID = id
+	value.Password = password
+	value.PasswordChangedAt = passwordChangedAt.Time
+	return id, nil
 }
 
-func (self *users) UpdateIt(db *sql.DB, value *User) error {
+// CreateIt 使用 context.Background() 调用 CreateItContext
+func (self *users) CreateIt(db Executor, value *User) (int64, error) {
+	return self.CreateItContext(context.Background(), db, value)
+}
+
+// UpdateItContext 更新用户, 当 value.Password 与数据库中的不同时视为设置了新密码,
+// 新密码会按密码策略检查后加密保存, value.Password 会被替换为加密后的密码。
+// 它不修改用户的状态, value.State 会被替换为数据库中的状态, 状态只能通过 Activate,
+// Disable, Lock 和 Unlock 等方法修改, 以便检查转换规则并记录变更。
+func (self *users) UpdateItContext(ctx context.Context, db Executor, value *User) error {
 	if 0 == value.ID {
 		return ThrowPrimaryKeyInvalid("tpt_users")
 	}
 
-	updateString := "UPDATE tpt_users SET name=?, description=?, password=?, phone=?, email=?, state=?, updated_at=? WHERE id = ?"
-	updateString, err := PlaceholderFormat(updateString)
-	if err != nil {
+	value.Email = NormalizeEmail(value.Email)
+	value.Phone = NormalizePhone(value.Phone)
+	if err := self.store.checkDeletedName(ctx, db, "tpt_users", value.Name, value.ID); err != nil {
 		return err
 	}
-
-	result, err := db.Exec(updateString,
-		value.Name,
-		value.Description,
-		value.Password,
-		value.Phone,
-		value.Email,
-		value.State,
-		time.Now(),
-		value.ID)
-	if nil != err {
+	if err := self.checkUnique(ctx, db, value); err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
-	if nil != err {
+
+	queryString, err := self.store.rebind("SELECT password, state FROM " + self.store.TableName("tpt_users") + " WHERE id = ? AND deleted_at IS NULL")
+	if err != nil {
 		return err
 	}
-	if 0 == rowsAffected {
-		return ErrNotUpdated
+	var stored sql.NullString
+	var state int64
+	if err := db.QueryRowContext(ctx, queryString, value.ID).Scan(&stored, &state); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotUpdated
+		}
+		return err
 	}
-	return nil
-}
 
-func (self *users) DeleteIt(db *sql.DB, value *User) error {
-	return self.DeleteByID(db, value.ID)
-}
-
-func (self *users) DeleteByID(db *sql.DB, key int64) error {
-	if 0 == key {
-		return ThrowPrimaryKeyInvalid("tpt_users")
+	password := value.Password
+	passwordChanged := password != stored.String && password != ""
+	if passwordChanged {
+		password, err = self.hashNewPassword(ctx, db, value.ID, password)
+		if err != nil {
+			return err
+		}
 	}
 
-	deleteString := "DELETE FROM tpt_users WHERE id = ?"
-	deleteString, err := PlaceholderFormat(deleteString)
-	if err != nil {
-		return err
+	now := time.Now()
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET name=?, description=?, password=?, phone=?, email=?, must_change_password=?, updated_at=?"
+	args := []interface{}{
+		value.Name,
+		value.Description,
+		password,
+		value.Phone,
+		value.Email,
+		value.MustChangePassword,
+		now,
+	}
+	if passwordChanged {
+		updateString += ", password_changed_at=?"
+		args = append(args, now)
 	}
-	result, err := db.Exec(deleteString, key)
+	updateString += " WHERE id = ? AND deleted_at IS NULL"
+	args = append(args, value.ID)
+
+	updateString, err = self.store.rebind(updateString)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	// 用户, 属性中的用户名和历史密码在同一个事务中修改
+	err = inTx(ctx, db, func(db Executor) error {
+		result, err := db.ExecContext(ctx, updateString, args...)
+		if nil != err {
+			return toDuplicateError("tpt_users", err)
+		}
+		rowsAffected, err := result.RowsAffected()
+		if nil != err {
+			return err
+		}
+		if 0 == rowsAffected {
+			return ErrNotUpdated
+		}
+
+		// 属性中的 usr 是用户名的冗余, 用户改名后同步修改它
+		if err := self.store.execWith(ctx, db, "UPDATE "+self.store.TableName("tpt_user_profiles")+" SET usr = ? WHERE user_id = ?", value.Name, value.ID); err != nil {
+			return err
+		}
+		if passwordChanged {
+			return self.addPasswordHistory(ctx, db, value.ID, password, now)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrNotDeleted
+
+	value.Password = password
+	value.State = state
+	if passwordChanged {
+		value.PasswordChangedAt = now
 	}
 	return nil
 }
 
-type userProfiles struct{}
+// UpdateIt 使用 context.Background() 调用 UpdateItContext
+func (self *users) UpdateIt(db Executor, value *User) error {
+	return self.UpdateItContext(context.Background(), db, value)
+}
+
+func (self *users) DeleteItContext(ctx context.Context, db Executor, value *User) error {
+	return self.DeleteByIDContext(ctx, db, value.ID)
+}
+
+func (self *users) DeleteIt(db Executor, value *User) error {
+	return self.DeleteItContext(context.Background(), db, value)
+}
+
+// DeleteByIDContext 软删除一条记录, 见 PurgeByID
+func (self *users) DeleteByIDContext(ctx context.Context, db Executor, key int64) error {
+	return self.store.softDelete(ctx, db, "tpt_users", key)
+}
+
+// DeleteByID 使用 context.Background() 调用 DeleteByIDContext
+func (self *users) DeleteByID(db Executor, key int64) error {
+	return self.DeleteByIDContext(context.Background(), db, key)
+}
+
+type userProfiles struct {
+	store *Store
+}
 
 func (self *userProfiles) scan(scanner RowScanner) (*UserProfile, error) {
 	var value UserProfile
+	var nullUserID sql.NullInt64
 	var nullUser sql.NullString
 	var nullName sql.NullString
 	var nullValue sql.NullString
-	var nullCreatedAt pq.NullTime
-	var nullUpdatedAt pq.NullTime
+	var nullVersion sql.NullInt64
+	var nullCreatedAt sql.NullTime
+	var nullUpdatedAt sql.NullTime
 
 	e := scanner.Scan(
 		&value.ID,
+		&nullUserID,
 		&nullUser,
 		&nullName,
 		&nullValue,
+		&nullVersion,
 		&nullCreatedAt,
 		&nullUpdatedAt)
 	if nil != e {
 		return nil, e
 	}
 
+	if nullUserID.Valid {
+		value.UserID = nullUserID.Int64
+	}
 	if nullUser.Valid {
 		value.User = nullUser.String
 	}
@@ -434,6 +712,9 @@ func (self *userProfiles) scan(scanner RowScanner) (*UserProfile, error) {
 	if nullValue.Valid {
 		value.Value = nullValue.String
 	}
+	if nullVersion.Valid {
+		value.Version = nullVersion.Int64
+	}
 	if nullCreatedAt.Valid {
 		value.CreatedAt = nullCreatedAt.Time
 	}
@@ -443,25 +724,34 @@ func (self *userProfiles) scan(scanner RowScanner) (*UserProfile, error) {
 	return &value, nil
 }
 
-const userProfilePrefix = "select id, usr, name, value, created_at, updated_at from tpt_user_profiles "
+const userProfilePrefix = "select id, user_id, usr, name, value, version, created_at, updated_at from "
 
-func (self *userProfiles) QueryRowWith(db *sql.DB, queryString string, args ...interface{}) (*UserProfile, error) {
-	queryString, err := PlaceholderFormat(queryString)
+// from 返回查询的表, 别名总是 tpt_user_profiles
+func (self *userProfiles) from() string {
+	return self.store.TableName("tpt_user_profiles") + " tpt_user_profiles "
+}
+
+func (self *userProfiles) QueryRowWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) (*UserProfile, error) {
+	queryString, err := self.store.rebind(userProfilePrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	row := db.QueryRow(userProfilePrefix+queryString, args...)
+	row := db.QueryRowContext(ctx, queryString, args...)
 	return self.scan(row)
 }
 
-func (self *userProfiles) QueryWith(db *sql.DB, queryString string, args ...interface{}) ([]*UserProfile, error) {
-	queryString, err := PlaceholderFormat(queryString)
+func (self *userProfiles) QueryRowWith(db Executor, queryString string, args ...interface{}) (*UserProfile, error) {
+	return self.QueryRowWithContext(context.Background(), db, queryString, args...)
+}
+
+func (self *userProfiles) QueryWithContext(ctx context.Context, db Executor, queryString string, args ...interface{}) ([]*UserProfile, error) {
+	queryString, err := self.store.rebind(userProfilePrefix + self.from() + queryString)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := db.Query(userProfilePrefix+queryString, args...)
+	rows, err := db.QueryContext(ctx, queryString, args...)
 	if nil != err {
 		return nil, err
 	}
@@ -478,31 +768,30 @@ func (self *userProfiles) QueryWith(db *sql.DB, queryString string, args ...inte
 	return results, rows.Err()
 }
 
-func (self *userProfiles) FindByID(db *sql.DB, id int64) (*UserProfile, error) {
-	return self.QueryRowWith(db, "WHERE id = ?", id)
+func (self *userProfiles) QueryWith(db Executor, queryString string, args ...interface{}) ([]*UserProfile, error) {
+	return self.QueryWithContext(context.Background(), db, queryString, args...)
 }
 
-func (self *userProfiles) CreateIt(db *sql.DB, value *UserProfile) (int64, error) {
-	sqlString := "INSERT INTO tpt_user_profiles(usr, name, value, created_at, updated_at) VALUES (?, ?, ?, ?, ?)"
-	sqlString, err := PlaceholderFormat(sqlString)
-	if err != nil {
-		return 0, err
-	}
+func (self *userProfiles) FindByIDContext(ctx context.Context, db Executor, id int64) (*UserProfile, error) {
+	return self.QueryRowWithContext(ctx, db, "WHERE id = ?", id)
+}
 
-	now := time.Now()
-	if IsReturning {
-		sqlString = sqlString + " RETURNING \"id\""
+func (self *userProfiles) FindByID(db Executor, id int64) (*UserProfile, error) {
+	return self.FindByIDContext(context.Background(), db, id)
+}
 
-		err := db.QueryRow(sqlString,
-			value.User,
-			value.Name,
-			value.Value,
-			now,
-			now).Scan(&value.ID)
-		return value.ID, err
+// CreateItContext 创建一条记录, 属性有声明时值必须符合声明, 见 RegisterProfileKey
+func (self *userProfiles) CreateItContext(ctx context.Context, db Executor, value *UserProfile) (int64, error) {
+	if err := checkProfile(value.Name, value.Value); err != nil {
+		return 0, err
+	}
+	if err := self.resolveOwner(ctx, db, value); err != nil {
+		return 0, err
 	}
 
-	result, err := db.Exec(sqlString,
+	now := time.Now()
+	id, err := self.store.insertWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_user_profiles")+"(user_id, usr, name, value, version, created_at, updated_at) VALUES (?, ?, ?, ?, 1, ?, ?)",
+		value.UserID,
 		value.User,
 		value.Name,
 		value.Value,
@@ -511,26 +800,42 @@ func (self *userProfiles) CreateIt(db *sql.DB, value *UserProfile) (int64, error
 	if nil != err {
 		return 0, err
 	}
-	return result.LastInsertId()
+	value.ID = id
+	value.Version = 1
+	return id, nil
 }
 
-func (self *userProfiles) UpdateIt(db *sql.DB, value *UserProfile) error {
+func (self *userProfiles) CreateIt(db Executor, value *UserProfile) (int64, error) {
+	return self.CreateItContext(context.Background(), db, value)
+}
+
+// UpdateItContext 更新一条记录, value.Version 必须与数据库中的版本一致, 否则返回 ErrProfileConflict,
+// 更新成功后 value.Version 加 1。与 CreateItContext 一样, 属性有声明时值必须符合声明。
+func (self *userProfiles) UpdateItContext(ctx context.Context, db Executor, value *UserProfile) error {
 	if 0 == value.ID {
 		return ThrowPrimaryKeyInvalid("tpt_user_profiles")
 	}
+	if err := checkProfile(value.Name, value.Value); err != nil {
+		return err
+	}
+	if err := self.resolveOwner(ctx, db, value); err != nil {
+		return err
+	}
 
-	updateString := "UPDATE tpt_user_profiles SET usr=?, name=?, value=?, updated_at=? WHERE id = ?"
-	updateString, err := PlaceholderFormat(updateString)
+	updateString := "UPDATE " + self.store.TableName("tpt_user_profiles") + " SET user_id=?, usr=?, name=?, value=?, version=version+1, updated_at=? WHERE id = ? AND version = ?"
+	updateString, err := self.store.rebind(updateString)
 	if err != nil {
 		return err
 	}
 
-	result, err := db.Exec(updateString,
+	result, err := db.ExecContext(ctx, updateString,
+		value.UserID,
 		value.User,
 		value.Name,
 		value.Value,
 		time.Now(),
-		value.ID)
+		value.ID,
+		value.Version)
 	if nil != err {
 		return err
 	}
@@ -539,26 +844,39 @@ func (self *userProfiles) UpdateIt(db *sql.DB, value *UserProfile) error {
 		return err
 	}
 	if 0 == rowsAffected {
+		if _, err := self.FindByIDContext(ctx, db, value.ID); err == nil {
+			return ErrProfileConflict
+		}
 		return ErrNotUpdated
 	}
+	value.Version++
 	return nil
 }
 
-func (self *userProfiles) DeleteIt(db *sql.DB, value *UserProfile) error {
-	return self.DeleteByID(db, value.ID)
+// UpdateIt 使用 context.Background() 调用 UpdateItContext
+func (self *userProfiles) UpdateIt(db Executor, value *UserProfile) error {
+	return self.UpdateItContext(context.Background(), db, value)
 }
 
-func (self *userProfiles) DeleteByID(db *sql.DB, key int64) error {
+func (self *userProfiles) DeleteItContext(ctx context.Context, db Executor, value *UserProfile) error {
+	return self.DeleteByIDContext(ctx, db, value.ID)
+}
+
+func (self *userProfiles) DeleteIt(db Executor, value *UserProfile) error {
+	return self.DeleteItContext(context.Background(), db, value)
+}
+
+func (self *userProfiles) DeleteByIDContext(ctx context.Context, db Executor, key int64) error {
 	if 0 == key {
 		return ThrowPrimaryKeyInvalid("tpt_user_profiles")
 	}
 
-	deleteString := "DELETE FROM tpt_user_profiles WHERE id = ?"
-	deleteString, err := PlaceholderFormat(deleteString)
+	deleteString := "DELETE FROM " + self.store.TableName("tpt_user_profiles") + " WHERE id = ?"
+	deleteString, err := self.store.rebind(deleteString)
 	if err != nil {
 		return err
 	}
-	result, err := db.Exec(deleteString, key)
+	result, err := db.ExecContext(ctx, deleteString, key)
 	if err != nil {
 		return err
 	}
@@ -571,3 +889,7 @@ func (self *userProfiles) DeleteByID(db *sql.DB, key int64) error {
 	}
 	return nil
 }
+
+func (self *userProfiles) DeleteByID(db Executor, key int64) error {
+	return self.DeleteByIDContext(context.Background(), db, key)
+}
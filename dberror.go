@@ -0,0 +1,133 @@
+package permissions
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// 各个数据库驱动返回的错误类型不同, 这里先按驱动提供的错误码判断, 驱动没有提供错误码时
+// 再按错误消息判断, 除了默认的 lib/pq 之外不依赖具体的驱动包。
+
+// sqlStateError 是按 SQLSTATE 报告错误的驱动(如 pgx)的错误
+type sqlStateError interface {
+	SQLState() string
+}
+
+// sqlNumberError 是 SQL Server 的驱动(go-mssqldb)的错误
+type sqlNumberError interface {
+	SQLErrorNumber() int32
+}
+
+// sqlState 返回 PostgreSQL 的错误码
+func sqlState(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code), true
+	}
+	var e sqlStateError
+	if errors.As(err, &e) {
+		return e.SQLState(), true
+	}
+	return "", false
+}
+
+// sqlErrorNumber 返回 SQL Server 的错误号
+func sqlErrorNumber(err error) (int32, bool) {
+	var e sqlNumberError
+	if errors.As(err, &e) {
+		return e.SQLErrorNumber(), true
+	}
+	return 0, false
+}
+
+var (
+	// postgres(pgx): ERROR: duplicate key value violates unique constraint "tpt_users_email_uq" (SQLSTATE 23505)
+	postgresDuplicatePattern = regexp.MustCompile(`unique constraint "(\w+)"`)
+	// sqlserver 2627: Violation of UNIQUE KEY constraint 'tpt_users_name_uq'. ...
+	// sqlserver 2601: Cannot insert duplicate key row in object 'dbo.tpt_users' with unique index 'tpt_users_email_uq'. ...
+	sqlServerDuplicatePattern = regexp.MustCompile(`(?:constraint|index) '(\w+)'`)
+
+	duplicateKeyPatterns = []*regexp.Regexp{
+		// sqlite: UNIQUE constraint failed: tpt_users.email
+		regexp.MustCompile(`UNIQUE constraint failed: \w+\.(\w+)`),
+		// mysql: Duplicate entry 'a@b.com' for key 'tpt_users_email_uq'
+		regexp.MustCompile(`Duplicate entry '.*' for key '(?:\w+\.)?(\w+)'`),
+		// oracle: ORA-00001: unique constraint (APP.TPT_USERS_EMAIL_UQ) violated
+		regexp.MustCompile(`ORA-00001: unique constraint \((?:\w+\.)?(\w+)\)`),
+	}
+)
+
+// duplicateKey 判断错误是否违反了唯一性约束, 并返回约束的名称(SQLite 返回的是字段名)
+func duplicateKey(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Constraint, pqErr.Code == "23505"
+	}
+	if code, ok := sqlState(err); ok {
+		if code != "23505" {
+			return "", false
+		}
+		return submatch(postgresDuplicatePattern, err.Error()), true
+	}
+	if number, ok := sqlErrorNumber(err); ok {
+		if number != 2601 && number != 2627 {
+			return "", false
+		}
+		return submatch(sqlServerDuplicatePattern, err.Error()), true
+	}
+
+	for _, pattern := range duplicateKeyPatterns {
+		if matches := pattern.FindStringSubmatch(err.Error()); matches != nil {
+			// Oracle 的约束名称是大写的
+			return strings.ToLower(matches[1]), true
+		}
+	}
+	return "", false
+}
+
+func submatch(pattern *regexp.Regexp, s string) string {
+	if matches := pattern.FindStringSubmatch(s); matches != nil {
+		return strings.ToLower(matches[1])
+	}
+	return ""
+}
+
+// isSerializationFailure 判断错误是否是可以通过重试事务解决的序列化失败或死锁
+func isSerializationFailure(err error) bool {
+	if code, ok := sqlState(err); ok {
+		return code == "40001" || code == "40P01"
+	}
+	if number, ok := sqlErrorNumber(err); ok {
+		// 1205: Transaction was deadlocked on lock resources with another process
+		return number == 1205
+	}
+
+	msg := err.Error()
+	// sqlite: database is locked, mysql: Error 1213: Deadlock found when trying to get lock,
+	// oracle: ORA-08177: can't serialize access for this transaction, ORA-00060: deadlock detected
+	return strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "Deadlock found") ||
+		strings.Contains(msg, "ORA-08177") ||
+		strings.Contains(msg, "ORA-00060")
+}
+
+// isUndefinedTable 判断错误是否是表不存在
+func isUndefinedTable(err error) bool {
+	if code, ok := sqlState(err); ok {
+		return code == "42P01"
+	}
+	if number, ok := sqlErrorNumber(err); ok {
+		// 208: Invalid object name 'tpt_users'.
+		return number == 208
+	}
+
+	msg := err.Error()
+	// sqlite: no such table: tpt_users, mysql: Error 1146: Table 'app.tpt_users' doesn't exist,
+	// oracle: ORA-00942: table or view does not exist
+	return strings.Contains(msg, "no such table") ||
+		strings.Contains(msg, "Error 1146") ||
+		strings.Contains(msg, "ORA-00942")
+}
@@ -0,0 +1,108 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// stateError 模拟 pgx 的错误
+type stateError struct {
+	code string
+	msg  string
+}
+
+func (e *stateError) Error() string    { return e.msg }
+func (e *stateError) SQLState() string { return e.code }
+
+// numberError 模拟 go-mssqldb 的错误
+type numberError struct {
+	number int32
+	msg    string
+}
+
+func (e *numberError) Error() string         { return e.msg }
+func (e *numberError) SQLErrorNumber() int32 { return e.number }
+
+func TestToDuplicateError(t *testing.T) {
+	for _, test := range []struct {
+		err   error
+		field string
+	}{
+		{&pq.Error{Code: "23505", Constraint: "tpt_users_email_uq"}, "email"},
+		{fmt.Errorf("create: %w", &pq.Error{Code: "23505", Constraint: "app_users_name_uq"}), "name"},
+		{&stateError{"23505", `ERROR: duplicate key value violates unique constraint "tpt_users_phone_uq" (SQLSTATE 23505)`}, "phone"},
+		{&numberError{2627, "mssql: Violation of UNIQUE KEY constraint 'tpt_users_name_uq'. Cannot insert duplicate key in object 'dbo.tpt_users'. The duplicate key value is (a)."}, "name"},
+		{&numberError{2601, "mssql: Cannot insert duplicate key row in object 'dbo.tpt_users' with unique index 'tpt_users_email_uq'. The duplicate key value is (a@b.com)."}, "email"},
+		{errors.New("ORA-00001: unique constraint (APP.TPT_USERS_EMAIL_UQ) violated"), "email"},
+		{errors.New("Error 1062: Duplicate entry 'a@b.com' for key 'tpt_users.tpt_users_email_uq'"), "email"},
+		{errors.New("UNIQUE constraint failed: tpt_users.email"), "email"},
+	} {
+		e, ok := toDuplicateError("tpt_users", test.err).(*DuplicateError)
+		if !ok {
+			t.Error("want DuplicateError, got", test.err)
+			continue
+		}
+		if e.Field != test.field {
+			t.Errorf("%v: want %s, got %s", test.err, test.field, e.Field)
+		}
+	}
+
+	for _, err := range []error{
+		&pq.Error{Code: "23503", Constraint: "tpt_user_roles_user_id_fkey"},
+		&stateError{"40001", "could not serialize access"},
+		&numberError{547, "mssql: The INSERT statement conflicted with the FOREIGN KEY constraint 'x'."},
+		errors.New("ORA-02291: integrity constraint (APP.X) violated"),
+	} {
+		if e := toDuplicateError("tpt_users", err); e != err {
+			t.Error("want the original error, got", e)
+		}
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{fmt.Errorf("tx: %w", &pq.Error{Code: "40P01"}), true},
+		{&pq.Error{Code: "23505"}, false},
+		{&stateError{"40001", "ERROR: could not serialize access (SQLSTATE 40001)"}, true},
+		{&numberError{1205, "mssql: Transaction (Process ID 52) was deadlocked on lock resources with another process"}, true},
+		{&numberError{2627, "mssql: Violation of UNIQUE KEY constraint 'x'."}, false},
+		{errors.New("ORA-08177: can't serialize access for this transaction"), true},
+		{errors.New("ORA-00060: deadlock detected while waiting for resource"), true},
+		{errors.New("Error 1213: Deadlock found when trying to get lock; try restarting transaction"), true},
+		{errors.New("database is locked"), true},
+		{errors.New("ORA-00001: unique constraint (APP.X) violated"), false},
+	} {
+		if got := isSerializationFailure(test.err); got != test.want {
+			t.Errorf("%v: want %v, got %v", test.err, test.want, got)
+		}
+	}
+}
+
+func TestIsUndefinedTable(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "42P01"}, true},
+		{&pq.Error{Code: "42501"}, false},
+		{&stateError{"42P01", `ERROR: relation "tpt_users" does not exist (SQLSTATE 42P01)`}, true},
+		{&numberError{208, "mssql: Invalid object name 'tpt_users'."}, true},
+		{&numberError{229, "mssql: The SELECT permission was denied on the object 'tpt_users'."}, false},
+		{errors.New("ORA-00942: table or view does not exist"), true},
+		{errors.New("Error 1146: Table 'app.tpt_users' doesn't exist"), true},
+		{errors.New("Error 1142: SELECT command denied to user 'app'@'localhost' for table 'tpt_users'"), false},
+		{errors.New("no such table: tpt_users"), true},
+		{errors.New("sql: database is closed"), false},
+	} {
+		if got := isUndefinedTable(test.err); got != test.want {
+			t.Errorf("%v: want %v, got %v", test.err, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package permissions
+
+import (
+	"strconv"
+	"strings"
+)
+
+// InsertIDStyle 是获取新插入的记录的 id 的方式
+type InsertIDStyle int
+
+const (
+	// InsertIDLastInsertID 执行插入语句后调用 sql.Result.LastInsertId(), 如 MySQL 和 SQLite
+	InsertIDLastInsertID InsertIDStyle = iota
+	// InsertIDReturning 在插入语句后加上 RETURNING id, 如 PostgreSQL
+	InsertIDReturning
+	// InsertIDOutput 在插入语句的 VALUES 前加上 OUTPUT INSERTED.id, 如 SQL Server
+	InsertIDOutput
+	// InsertIDReturningInto 在插入语句后加上 RETURNING id INTO ?, 并用 sql.Out 接收 id, 如 Oracle
+	InsertIDReturningInto
+)
+
+// Dialect 描述不同数据库之间的差异, 所有的 DAO 都通过它生成 SQL 语句
+type Dialect interface {
+	// Name 数据库的名称, 例如 postgres
+	Name() string
+	// Placeholder 将 SQL 语句中的 ? 占位符替换为数据库使用的占位符, ?? 表示一个问号
+	Placeholder(sql string) (string, error)
+	// Quote 给标识符(表名或列名)加上引号
+	Quote(identifier string) string
+	// InsertID 获取新插入的记录的 id 的方式
+	InsertID() InsertIDStyle
+	// Upsert 返回一个插入 columns 的语句, keys 上的唯一性约束冲突时改为更新 updates,
+	// 语句中每个列有一个 ? 占位符, 参数的顺序与 columns 相同。不支持时返回空字符串。
+	Upsert(table string, keys, columns, updates []string) string
+	// TimestampType 建表时使用的时间戳类型
+	TimestampType() string
+	// Paginate 返回跟在 ORDER BY 之后的分页子句, limit 总是大于 0
+	Paginate(limit, offset int64) string
+}
+
+var (
+	// Postgres 是 PostgreSQL 的 Dialect
+	Postgres Dialect = postgresDialect{}
+	// MySQL 是 MySQL 的 Dialect
+	MySQL Dialect = mysqlDialect{}
+	// SQLite 是 SQLite 的 Dialect
+	SQLite Dialect = sqliteDialect{}
+	// SQLServer 是 SQL Server 的 Dialect
+	SQLServer Dialect = sqlserverDialect{}
+	// Oracle 是 Oracle 的 Dialect
+	Oracle Dialect = oracleDialect{}
+)
+
+// DialectByName 按名称查找内置的 Dialect, 名称同 database/sql 中常用的驱动名
+func DialectByName(name string) (Dialect, bool) {
+	switch strings.ToLower(name) {
+	case "postgres", "postgresql", "pgx":
+		return Postgres, true
+	case "mysql":
+		return MySQL, true
+	case "sqlite", "sqlite3":
+		return SQLite, true
+	case "sqlserver", "mssql":
+		return SQLServer, true
+	case "oracle", "godror", "oci8":
+		return Oracle, true
+	default:
+		return nil, false
+	}
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                           { return "postgres" }
+func (postgresDialect) Placeholder(sql string) (string, error) { return Dollar(sql) }
+func (postgresDialect) Quote(identifier string) string         { return quoteWith(identifier, `"`, `"`) }
+func (postgresDialect) InsertID() InsertIDStyle                { return InsertIDReturning }
+func (postgresDialect) TimestampType() string                  { return "timestamp with time zone" }
+func (postgresDialect) Paginate(limit, offset int64) string    { return limitOffset(limit, offset) }
+
+func (d postgresDialect) Upsert(table string, keys, columns, updates []string) string {
+	return onConflictUpsert(d, table, keys, columns, updates)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string                           { return "mysql" }
+func (mysqlDialect) Placeholder(sql string) (string, error) { return Question(sql) }
+func (mysqlDialect) Quote(identifier string) string         { return quoteWith(identifier, "`", "`") }
+func (mysqlDialect) InsertID() InsertIDStyle                { return InsertIDLastInsertID }
+func (mysqlDialect) TimestampType() string                  { return "datetime(6)" }
+func (mysqlDialect) Paginate(limit, offset int64) string    { return limitOffset(limit, offset) }
+
+func (d mysqlDialect) Upsert(table string, keys, columns, updates []string) string {
+	var buf strings.Builder
+	writeInsert(&buf, d, table, columns)
+	buf.WriteString(" ON DUPLICATE KEY UPDATE ")
+	for i, column := range updates {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(d.Quote(column))
+		buf.WriteString(" = VALUES(")
+		buf.WriteString(d.Quote(column))
+		buf.WriteString(")")
+	}
+	return buf.String()
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                           { return "sqlite3" }
+func (sqliteDialect) Placeholder(sql string) (string, error) { return Question(sql) }
+func (sqliteDialect) Quote(identifier string) string         { return quoteWith(identifier, `"`, `"`) }
+func (sqliteDialect) InsertID() InsertIDStyle                { return InsertIDLastInsertID }
+func (sqliteDialect) TimestampType() string                  { return "datetime" }
+func (sqliteDialect) Paginate(limit, offset int64) string    { return limitOffset(limit, offset) }
+
+func (d sqliteDialect) Upsert(table string, keys, columns, updates []string) string {
+	return onConflictUpsert(d, table, keys, columns, updates)
+}
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) Name() string                           { return "sqlserver" }
+func (sqlserverDialect) Placeholder(sql string) (string, error) { return AtP(sql) }
+func (sqlserverDialect) Quote(identifier string) string         { return quoteWith(identifier, "[", "]") }
+func (sqlserverDialect) InsertID() InsertIDStyle                { return InsertIDOutput }
+func (sqlserverDialect) TimestampType() string                  { return "datetimeoffset" }
+func (sqlserverDialect) Paginate(limit, offset int64) string    { return offsetFetch(limit, offset) }
+
+func (d sqlserverDialect) Upsert(table string, keys, columns, updates []string) string {
+	// HOLDLOCK 避免两个连接同时插入同一条记录, 见 MERGE 的文档
+	return mergeUpsert(d, table+" WITH (HOLDLOCK)", keys, columns, updates, "") + ";"
+}
+
+type oracleDialect struct{}
+
+func (oracleDialect) Name() string                           { return "oracle" }
+func (oracleDialect) Placeholder(sql string) (string, error) { return Colon(sql) }
+func (oracleDialect) InsertID() InsertIDStyle                { return InsertIDReturningInto }
+func (oracleDialect) TimestampType() string                  { return "timestamp with time zone" }
+func (oracleDialect) Paginate(limit, offset int64) string    { return offsetFetch(limit, offset) }
+
+// Quote 没有加引号的标识符在 Oracle 中会被转为大写, 所以这里也转为大写,
+// 以便与建表时没有加引号的表名和列名一致
+func (oracleDialect) Quote(identifier string) string {
+	return quoteWith(strings.ToUpper(identifier), `"`, `"`)
+}
+
+func (d oracleDialect) Upsert(table string, keys, columns, updates []string) string {
+	return mergeUpsert(d, table, keys, columns, updates, " FROM dual")
+}
+
+// quoteWith 给标识符加上引号, 标识符中的 schema.table 会分别加上引号
+func quoteWith(identifier, left, right string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = left + strings.Replace(part, right, right+right, -1) + right
+	}
+	return strings.Join(parts, ".")
+}
+
+func limitOffset(limit, offset int64) string {
+	if offset > 0 {
+		return " LIMIT " + strconv.FormatInt(limit, 10) + " OFFSET " + strconv.FormatInt(offset, 10)
+	}
+	return " LIMIT " + strconv.FormatInt(limit, 10)
+}
+
+// offsetFetch 是 SQL:2008 的分页语法, SQL Server 要求语句中必须有 ORDER BY
+func offsetFetch(limit, offset int64) string {
+	return " OFFSET " + strconv.FormatInt(offset, 10) + " ROWS FETCH NEXT " + strconv.FormatInt(limit, 10) + " ROWS ONLY"
+}
+
+func writeInsert(buf *strings.Builder, d Dialect, table string, columns []string) {
+	buf.WriteString("INSERT INTO ")
+	buf.WriteString(table)
+	buf.WriteString("(")
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(d.Quote(column))
+	}
+	buf.WriteString(") VALUES (?")
+	buf.WriteString(strings.Repeat(", ?", len(columns)-1))
+	buf.WriteString(")")
+}
+
+func onConflictUpsert(d Dialect, table string, keys, columns, updates []string) string {
+	var buf strings.Builder
+	writeInsert(&buf, d, table, columns)
+	buf.WriteString(" ON CONFLICT (")
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(d.Quote(key))
+	}
+	buf.WriteString(") DO UPDATE SET ")
+	for i, column := range updates {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(d.Quote(column))
+		buf.WriteString(" = EXCLUDED.")
+		buf.WriteString(d.Quote(column))
+	}
+	return buf.String()
+}
+
+func mergeUpsert(d Dialect, table string, keys, columns, updates []string, from string) string {
+	var buf strings.Builder
+	buf.WriteString("MERGE INTO ")
+	buf.WriteString(table)
+	buf.WriteString(" t USING (SELECT ")
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("? AS ")
+		buf.WriteString(d.Quote(column))
+	}
+	buf.WriteString(from)
+	buf.WriteString(") s ON (")
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteString(" AND ")
+		}
+		buf.WriteString("t." + d.Quote(key) + " = s." + d.Quote(key))
+	}
+	buf.WriteString(") WHEN MATCHED THEN UPDATE SET ")
+	for i, column := range updates {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("t." + d.Quote(column) + " = s." + d.Quote(column))
+	}
+	buf.WriteString(" WHEN NOT MATCHED THEN INSERT (")
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(d.Quote(column))
+	}
+	buf.WriteString(") VALUES (")
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("s." + d.Quote(column))
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+// defaultDialect 在每次调用时读取 PlaceholderFormat 和 IsReturning, 供 DefaultStore 使用。
+// 它不知道具体是哪种数据库, 所以标识符不加引号, 也不支持 upsert。
+type defaultDialect struct{}
+
+func (defaultDialect) Name() string                           { return "" }
+func (defaultDialect) Placeholder(sql string) (string, error) { return PlaceholderFormat(sql) }
+func (defaultDialect) Quote(identifier string) string         { return identifier }
+func (defaultDialect) TimestampType() string                  { return "timestamp" }
+func (defaultDialect) Paginate(limit, offset int64) string    { return limitOffset(limit, offset) }
+
+func (defaultDialect) InsertID() InsertIDStyle {
+	if IsReturning {
+		return InsertIDReturning
+	}
+	return InsertIDLastInsertID
+}
+
+func (defaultDialect) Upsert(table string, keys, columns, updates []string) string {
+	return ""
+}
@@ -0,0 +1,150 @@
+package permissions
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDialectPlaceholder(t *testing.T) {
+	for _, test := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{Postgres, "SELECT * FROM t WHERE a = $1 AND b = $2 AND c ? 'x'"},
+		{MySQL, "SELECT * FROM t WHERE a = ? AND b = ? AND c ?? 'x'"},
+		{SQLite, "SELECT * FROM t WHERE a = ? AND b = ? AND c ?? 'x'"},
+		{SQLServer, "SELECT * FROM t WHERE a = @p1 AND b = @p2 AND c ? 'x'"},
+		{Oracle, "SELECT * FROM t WHERE a = :1 AND b = :2 AND c ? 'x'"},
+	} {
+		sqlString, err := test.dialect.Placeholder("SELECT * FROM t WHERE a = ? AND b = ? AND c ?? 'x'")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if sqlString != test.want {
+			t.Errorf("%s: want %q, got %q", test.dialect.Name(), test.want, sqlString)
+		}
+	}
+}
+
+func TestDialectQuote(t *testing.T) {
+	for _, test := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{Postgres, `"public"."tpt_users"`},
+		{MySQL, "`public`.`tpt_users`"},
+		{SQLite, `"public"."tpt_users"`},
+		{SQLServer, "[public].[tpt_users]"},
+		{Oracle, `"PUBLIC"."TPT_USERS"`},
+	} {
+		if got := test.dialect.Quote("public.tpt_users"); got != test.want {
+			t.Errorf("%s: want %s, got %s", test.dialect.Name(), test.want, got)
+		}
+	}
+}
+
+func TestDialectPaginate(t *testing.T) {
+	for _, test := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{Postgres, " LIMIT 10 OFFSET 20"},
+		{MySQL, " LIMIT 10 OFFSET 20"},
+		{SQLite, " LIMIT 10 OFFSET 20"},
+		{SQLServer, " OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+		{Oracle, " OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY"},
+	} {
+		if got := test.dialect.Paginate(10, 20); got != test.want {
+			t.Errorf("%s: want %q, got %q", test.dialect.Name(), test.want, got)
+		}
+	}
+	if got := Postgres.Paginate(10, 0); got != " LIMIT 10" {
+		t.Errorf("want %q, got %q", " LIMIT 10", got)
+	}
+}
+
+func TestDialectUpsert(t *testing.T) {
+	keys := []string{"user_id"}
+	columns := []string{"user_id", "secret"}
+	updates := []string{"secret"}
+	for _, test := range []struct {
+		dialect Dialect
+		want    string
+	}{
+		{Postgres, `INSERT INTO t("user_id", "secret") VALUES (?, ?) ON CONFLICT ("user_id") DO UPDATE SET "secret" = EXCLUDED."secret"`},
+		{MySQL, "INSERT INTO t(`user_id`, `secret`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `secret` = VALUES(`secret`)"},
+		{SQLServer, "MERGE INTO t WITH (HOLDLOCK) t USING (SELECT ? AS [user_id], ? AS [secret]) s ON (t.[user_id] = s.[user_id])" +
+			" WHEN MATCHED THEN UPDATE SET t.[secret] = s.[secret]" +
+			" WHEN NOT MATCHED THEN INSERT ([user_id], [secret]) VALUES (s.[user_id], s.[secret]);"},
+		{Oracle, `MERGE INTO t t USING (SELECT ? AS "USER_ID", ? AS "SECRET" FROM dual) s ON (t."USER_ID" = s."USER_ID")` +
+			` WHEN MATCHED THEN UPDATE SET t."SECRET" = s."SECRET"` +
+			` WHEN NOT MATCHED THEN INSERT ("USER_ID", "SECRET") VALUES (s."USER_ID", s."SECRET")`},
+	} {
+		if got := test.dialect.Upsert("t", keys, columns, updates); got != test.want {
+			t.Errorf("%s: want\n%s\ngot\n%s", test.dialect.Name(), test.want, got)
+		}
+	}
+}
+
+func TestDialectStore(t *testing.T) {
+	dialect, ok := DialectByName(*driverName)
+	if !ok {
+		t.Skip("dialect of '" + *driverName + "' is unknown")
+	}
+
+	dbTest(t, func(db *sql.DB) {
+		store := NewStore(db, dialect, nil)
+
+		user := &User{Name: "dialect1"}
+		id, err := store.Users.CreateIt(db, user)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if id == 0 || user.ID != id {
+			t.Error("want id, got", id, user.ID)
+		}
+
+		role := &Role{Name: "dialect_role1"}
+		if _, err := store.Roles.CreateIt(db, role); err != nil {
+			t.Error(err)
+			return
+		}
+		if role.ID == 0 {
+			t.Error("id of role isn't set")
+		}
+
+		// 第二次登记时通过 upsert 覆盖第一次的密钥
+		first, err := store.Users.EnrollTOTP(db, user.ID, "test")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		second, err := store.Users.EnrollTOTP(db, user.ID, "test")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if first.Secret == second.Secret {
+			t.Error("secret isn't changed")
+		}
+		var secret string
+		err = db.QueryRow(mustRebind(t, store, "SELECT secret FROM tpt_user_totp WHERE user_id = ?"), user.ID).Scan(&secret)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if secret != second.Secret {
+			t.Error("want", second.Secret, "got", secret)
+		}
+	})
+}
+
+func mustRebind(t *testing.T, store *Store, sqlString string) string {
+	sqlString, err := store.rebind(sqlString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sqlString
+}
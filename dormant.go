@@ -0,0 +1,62 @@
+package permissions
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// DormantThreshold 用户多久没有登录后被视为休眠用户
+var DormantThreshold = 90 * 24 * time.Hour
+
+// ListDormantContext 列出超过 threshold 没有登录的正常状态的用户, 从未登录过的用户按创建时间计算。
+// threshold 小于等于 0 时使用 DormantThreshold, roles 不为空时只列出拥有其中任一角色的用户。
+func (self *users) ListDormantContext(ctx context.Context, db Executor, threshold time.Duration, roles ...string) ([]*User, error) {
+	if threshold <= 0 {
+		threshold = DormantThreshold
+	}
+
+	queryString := "WHERE state = ? AND COALESCE(last_login_at, created_at) < ?"
+	args := []interface{}{UserActive, time.Now().Add(-threshold)}
+	if len(roles) > 0 {
+		queryString += " AND EXISTS (SELECT * FROM " + self.store.TableName("tpt_user_roles") + " tpt_user_roles JOIN " + self.store.TableName("tpt_roles") + " tpt_roles ON tpt_user_roles.role_id = tpt_roles.id" +
+			" WHERE tpt_user_roles.user_id = tpt_users.id AND tpt_roles.deleted_at IS NULL" +
+			" AND tpt_roles.name IN (?" + strings.Repeat(", ?", len(roles)-1) + "))"
+		for _, role := range roles {
+			args = append(args, role)
+		}
+	}
+	return self.QueryWithContext(ctx, db, queryString+" ORDER BY id", args...)
+}
+
+// ListDormant 使用 context.Background() 调用 ListDormantContext
+func (self *users) ListDormant(db Executor, threshold time.Duration, roles ...string) ([]*User, error) {
+	return self.ListDormantContext(context.Background(), db, threshold, roles...)
+}
+
+// DisableDormantContext 禁用 ListDormant 列出的用户, 每个用户的状态变更都记录在 tpt_user_state_changes 中,
+// 返回被禁用的用户。其间状态被其它操作修改了的用户会被跳过。
+func (self *users) DisableDormantContext(ctx context.Context, db Executor, threshold time.Duration, operator, reason string, roles ...string) ([]*User, error) {
+	dormant, err := self.ListDormantContext(ctx, db, threshold, roles...)
+	if err != nil {
+		return nil, err
+	}
+
+	disabled := make([]*User, 0, len(dormant))
+	for _, user := range dormant {
+		err := self.changeState(ctx, db, user, UserDisabled, operator, reason)
+		if err != nil {
+			if err == ErrNotUpdated {
+				continue
+			}
+			return disabled, err
+		}
+		disabled = append(disabled, user)
+	}
+	return disabled, nil
+}
+
+// DisableDormant 使用 context.Background() 调用 DisableDormantContext
+func (self *users) DisableDormant(db Executor, threshold time.Duration, operator, reason string, roles ...string) ([]*User, error) {
+	return self.DisableDormantContext(context.Background(), db, threshold, operator, reason, roles...)
+}
@@ -0,0 +1,102 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestDormant(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		role1 := &Role{Name: "dormant_role1"}
+		if _, err := role1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var ids []int64
+		for _, name := range []string{"dormant1", "dormant2", "dormant3"} {
+			user := &User{Name: name, Password: name + "_pwd"}
+			if _, err := user.CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+			ids = append(ids, user.ID)
+		}
+		if err := Users.AddRole(db, ids[1], role1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+
+		user, err := AuthenticateFrom(db, "dormant3", "dormant3_pwd", "192.168.1.9")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.LastLoginAt.IsZero() {
+			t.Error("last_login_at isn't set")
+		}
+		user, err = Users.FindByID(db, ids[2])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.LastLoginIP != "192.168.1.9" || user.LastLoginAt.IsZero() {
+			t.Error("want 192.168.1.9, got", user.LastLoginIP, user.LastLoginAt)
+		}
+
+		// dormant1 和 dormant2 在 100 天前登录过, dormant3 刚刚登录
+		old := time.Now().Add(-100 * 24 * time.Hour)
+		if err := DefaultStore.execWith(context.Background(), db, "UPDATE tpt_users SET last_login_at = ? WHERE id IN (?, ?)", old, ids[0], ids[1]); err != nil {
+			t.Error(err)
+			return
+		}
+
+		dormant, err := Users.ListDormant(db, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dormant) != 2 || dormant[0].ID != ids[0] || dormant[1].ID != ids[1] {
+			t.Error("want dormant1 and dormant2, got", dormant)
+		}
+
+		dormant, err = Users.ListDormant(db, 200*24*time.Hour)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dormant) != 0 {
+			t.Error("want empty, got", dormant)
+		}
+
+		disabled, err := Users.DisableDormant(db, 0, "admin", "dormant", "dormant_role1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(disabled) != 1 || disabled[0].ID != ids[1] {
+			t.Error("want dormant2, got", disabled)
+			return
+		}
+
+		changes, err := Users.ListStateChanges(db, ids[1])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(changes) != 1 || changes[0].To != UserDisabled || changes[0].Reason != "dormant" {
+			t.Error("state change isn't recorded", changes)
+		}
+
+		dormant, err = Users.ListDormant(db, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dormant) != 1 || dormant[0].ID != ids[0] {
+			t.Error("want dormant1, got", dormant)
+		}
+	})
+}
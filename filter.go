@@ -0,0 +1,320 @@
+package permissions
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Filter 是类型安全的查询条件, 由 Eq, In, Like, Range, And 和 Or 构造。
+// 列名在生成 SQL 时按实体检查, 值都作为参数传递, 不会拼接到 SQL 语句中。
+type Filter interface {
+	build(b *filterBuilder)
+}
+
+var (
+	userFilterColumns = columnSet("id", "name", "description", "phone", "email", "state",
+		"failed_attempts", "locked_until", "password_changed_at", "must_change_password",
+		"deleted_at", "last_login_at", "last_login_ip", "created_at", "updated_at")
+
+	roleFilterColumns = columnSet("id", "name", "description", "permission_keys",
+		"deleted_at", "created_at", "updated_at")
+
+	profileFilterColumns = columnSet("id", "user_id", "usr", "name", "value", "version",
+		"created_at", "updated_at")
+)
+
+func columnSet(columns ...string) map[string]bool {
+	set := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		set[column] = true
+	}
+	return set
+}
+
+type eqFilter struct {
+	column string
+	value  interface{}
+}
+
+// Eq 表示 column = value, value 为 nil 时表示 column IS NULL
+func Eq(column string, value interface{}) Filter {
+	return eqFilter{column: column, value: value}
+}
+
+func (f eqFilter) build(b *filterBuilder) {
+	if f.value == nil {
+		b.writeColumn(f.column)
+		b.buf.WriteString(" IS NULL")
+		return
+	}
+	b.writeColumn(f.column)
+	b.buf.WriteString(" = ?")
+	b.bind(f.value)
+}
+
+type inFilter struct {
+	column string
+	values []interface{}
+}
+
+// In 表示 column IN (values...), values 为空时没有记录符合条件
+func In(column string, values ...interface{}) Filter {
+	return inFilter{column: column, values: values}
+}
+
+func (f inFilter) build(b *filterBuilder) {
+	if len(f.values) == 0 {
+		b.checkColumn(f.column)
+		b.buf.WriteString("1 = 0")
+		return
+	}
+	b.writeColumn(f.column)
+	b.buf.WriteString(" IN (?")
+	b.buf.WriteString(strings.Repeat(", ?", len(f.values)-1))
+	b.buf.WriteString(")")
+	for _, value := range f.values {
+		b.bind(value)
+	}
+}
+
+type likeFilter struct {
+	column  string
+	pattern string
+}
+
+// Like 表示 column LIKE pattern, 不区分大小写。pattern 中 % 和 _ 是通配符,
+// 需要匹配它们本身时用 ! 转义, 例如 "50!%%"
+func Like(column, pattern string) Filter {
+	return likeFilter{column: column, pattern: pattern}
+}
+
+func (f likeFilter) build(b *filterBuilder) {
+	b.buf.WriteString("LOWER(")
+	b.writeColumn(f.column)
+	b.buf.WriteString(") LIKE ? ESCAPE '!'")
+	b.bind(strings.ToLower(f.pattern))
+}
+
+type rangeFilter struct {
+	column   string
+	from, to interface{}
+}
+
+// Range 表示 from <= column < to, from 或 to 为 nil 时表示这一侧没有限制
+func Range(column string, from, to interface{}) Filter {
+	return rangeFilter{column: column, from: from, to: to}
+}
+
+func (f rangeFilter) build(b *filterBuilder) {
+	switch {
+	case f.from != nil && f.to != nil:
+		b.writeColumn(f.column)
+		b.buf.WriteString(" >= ? AND ")
+		b.writeColumn(f.column)
+		b.buf.WriteString(" < ?")
+		b.bind(f.from)
+		b.bind(f.to)
+	case f.from != nil:
+		b.writeColumn(f.column)
+		b.buf.WriteString(" >= ?")
+		b.bind(f.from)
+	case f.to != nil:
+		b.writeColumn(f.column)
+		b.buf.WriteString(" < ?")
+		b.bind(f.to)
+	default:
+		b.checkColumn(f.column)
+		b.buf.WriteString("1 = 1")
+	}
+}
+
+type groupFilter struct {
+	op      string
+	filters []Filter
+}
+
+// And 表示所有的 filters 都成立, filters 为空时所有记录都符合条件
+func And(filters ...Filter) Filter {
+	return groupFilter{op: " AND ", filters: filters}
+}
+
+// Or 表示 filters 中至少一个成立, filters 为空时没有记录符合条件
+func Or(filters ...Filter) Filter {
+	return groupFilter{op: " OR ", filters: filters}
+}
+
+func (f groupFilter) build(b *filterBuilder) {
+	if len(f.filters) == 0 {
+		if f.op == " AND " {
+			b.buf.WriteString("1 = 1")
+		} else {
+			b.buf.WriteString("1 = 0")
+		}
+		return
+	}
+	for i, filter := range f.filters {
+		if i > 0 {
+			b.buf.WriteString(f.op)
+		}
+		b.buf.WriteString("(")
+		if filter == nil {
+			b.fail(errors.New("filter is nil"))
+		} else {
+			filter.build(b)
+		}
+		b.buf.WriteString(")")
+	}
+}
+
+// filterBuilder 将 Filter 转换为带 ? 占位符的条件, 占位符由 Store.rebind 替换为数据库使用的形式
+type filterBuilder struct {
+	table   string
+	columns map[string]bool
+	buf     strings.Builder
+	args    []interface{}
+	err     error
+}
+
+func (b *filterBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *filterBuilder) checkColumn(column string) {
+	if !b.columns[column] {
+		b.fail(errors.New("column '" + column + "' of " + b.table + " is unknown"))
+	}
+}
+
+func (b *filterBuilder) writeColumn(column string) {
+	b.checkColumn(column)
+	b.buf.WriteString(b.table)
+	b.buf.WriteString(".")
+	b.buf.WriteString(column)
+}
+
+func (b *filterBuilder) bind(value interface{}) {
+	// DAO 写入的是本地时间, SQLite 按字符串比较时间, 所以这里也转换为本地时间
+	if t, ok := value.(time.Time); ok {
+		value = t.Local()
+	}
+	b.args = append(b.args, value)
+}
+
+// buildFilter 返回 filter 对应的条件和参数, filter 为 nil 时返回空字符串
+func buildFilter(table string, columns map[string]bool, filter Filter) (string, []interface{}, error) {
+	if filter == nil {
+		return "", nil, nil
+	}
+	b := &filterBuilder{table: table, columns: columns}
+	filter.build(b)
+	if b.err != nil {
+		return "", nil, b.err
+	}
+	return b.buf.String(), b.args, nil
+}
+
+// FindContext 查询符合 filter 的用户, 按 id 排序, filter 为 nil 时返回所有用户
+func (self *users) FindContext(ctx context.Context, db Executor, filter Filter) ([]*User, error) {
+	where, args, err := buildFilter("tpt_users", userFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryWithContext(ctx, db, where+"ORDER BY tpt_users.id", args...)
+}
+
+// Find 使用 context.Background() 调用 FindContext
+func (self *users) Find(db Executor, filter Filter) ([]*User, error) {
+	return self.FindContext(context.Background(), db, filter)
+}
+
+// FindOneContext 查询符合 filter 的一个用户, 没有时返回 sql.ErrNoRows
+func (self *users) FindOneContext(ctx context.Context, db Executor, filter Filter) (*User, error) {
+	where, args, err := buildFilter("tpt_users", userFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryRowWithContext(ctx, db, where+"ORDER BY tpt_users.id"+self.store.dialect.Paginate(1, 0), args...)
+}
+
+// FindOne 使用 context.Background() 调用 FindOneContext
+func (self *users) FindOne(db Executor, filter Filter) (*User, error) {
+	return self.FindOneContext(context.Background(), db, filter)
+}
+
+// FindContext 查询符合 filter 的角色, 按 id 排序, filter 为 nil 时返回所有角色
+func (self *roles) FindContext(ctx context.Context, db Executor, filter Filter) ([]*Role, error) {
+	where, args, err := buildFilter("tpt_roles", roleFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryWithContext(ctx, db, where+"ORDER BY tpt_roles.id", args...)
+}
+
+// Find 使用 context.Background() 调用 FindContext
+func (self *roles) Find(db Executor, filter Filter) ([]*Role, error) {
+	return self.FindContext(context.Background(), db, filter)
+}
+
+// FindOneContext 查询符合 filter 的一个角色, 没有时返回 sql.ErrNoRows
+func (self *roles) FindOneContext(ctx context.Context, db Executor, filter Filter) (*Role, error) {
+	where, args, err := buildFilter("tpt_roles", roleFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryRowWithContext(ctx, db, where+"ORDER BY tpt_roles.id"+self.store.dialect.Paginate(1, 0), args...)
+}
+
+// FindOne 使用 context.Background() 调用 FindOneContext
+func (self *roles) FindOne(db Executor, filter Filter) (*Role, error) {
+	return self.FindOneContext(context.Background(), db, filter)
+}
+
+// FindContext 查询符合 filter 的属性, 按 id 排序, filter 为 nil 时返回所有属性
+func (self *userProfiles) FindContext(ctx context.Context, db Executor, filter Filter) ([]*UserProfile, error) {
+	where, args, err := buildFilter("tpt_user_profiles", profileFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryWithContext(ctx, db, where+"ORDER BY tpt_user_profiles.id", args...)
+}
+
+// Find 使用 context.Background() 调用 FindContext
+func (self *userProfiles) Find(db Executor, filter Filter) ([]*UserProfile, error) {
+	return self.FindContext(context.Background(), db, filter)
+}
+
+// FindOneContext 查询符合 filter 的一个属性, 没有时返回 sql.ErrNoRows
+func (self *userProfiles) FindOneContext(ctx context.Context, db Executor, filter Filter) (*UserProfile, error) {
+	where, args, err := buildFilter("tpt_user_profiles", profileFilterColumns, filter)
+	if err != nil {
+		return nil, err
+	}
+	if where != "" {
+		where = "WHERE " + where + " "
+	}
+	return self.QueryRowWithContext(ctx, db, where+"ORDER BY tpt_user_profiles.id"+self.store.dialect.Paginate(1, 0), args...)
+}
+
+// FindOne 使用 context.Background() 调用 FindOneContext
+func (self *userProfiles) FindOne(db Executor, filter Filter) (*UserProfile, error) {
+	return self.FindOneContext(context.Background(), db, filter)
+}
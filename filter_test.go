@@ -0,0 +1,157 @@
+package permissions
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBuildFilter(t *testing.T) {
+	where, args, err := buildFilter("tpt_users", userFilterColumns, And(
+		Eq("name", "a'b"),
+		Or(In("state", UserActive, UserDisabled), Eq("deleted_at", nil)),
+		Like("email", "%@example.com"),
+		Range("id", 1, 10),
+	))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sqlString, err := Postgres.Placeholder(where)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := "(tpt_users.name = $1) AND ((tpt_users.state IN ($2, $3)) OR (tpt_users.deleted_at IS NULL))" +
+		" AND (LOWER(tpt_users.email) LIKE $4 ESCAPE '!') AND (tpt_users.id >= $5 AND tpt_users.id < $6)"
+	if sqlString != want {
+		t.Errorf("want %s\n got %s", want, sqlString)
+	}
+	if len(args) != 6 || args[0] != "a'b" {
+		t.Error("args is wrong,", args)
+	}
+
+	for _, filter := range []Filter{
+		Eq("password", "x"),
+		Eq("name = '' OR 1 = 1 --", "x"),
+		In("password"),
+		Range("password", nil, nil),
+		Or(Eq("name", "x"), Like("password", "%")),
+		And(nil),
+	} {
+		if _, _, err := buildFilter("tpt_users", userFilterColumns, filter); err == nil {
+			t.Errorf("%#v: want an error, got nil", filter)
+		}
+	}
+	if _, _, err := buildFilter("tpt_roles", roleFilterColumns, Eq("email", "x")); err == nil {
+		t.Error("roles has no email")
+	}
+}
+
+func TestFind(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		start := time.Now().Add(-time.Minute)
+		for i := 0; i < 6; i++ {
+			user := &User{Name: fmt.Sprintf("Find_%d", i), Email: fmt.Sprintf("find%d@example.com", i), State: UserActive}
+			if i%2 == 1 {
+				user.State = UserDisabled
+			}
+			if _, err := user.CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		for _, test := range []struct {
+			filter Filter
+			want   []string
+		}{
+			{Eq("name", "Find_1"), []string{"Find_1"}},
+			{In("name", "Find_2", "Find_4", "nobody"), []string{"Find_2", "Find_4"}},
+			{In("name"), nil},
+			{Like("name", "find!_%"), []string{"Find_0", "Find_1", "Find_2", "Find_3", "Find_4", "Find_5"}},
+			{And(Like("email", "find%"), Eq("state", UserDisabled)), []string{"Find_1", "Find_3", "Find_5"}},
+			{Or(Eq("name", "Find_0"), Eq("email", "find5@example.com")), []string{"Find_0", "Find_5"}},
+			{And(Like("name", "find%"), Range("created_at", start, nil)), []string{"Find_0", "Find_1", "Find_2", "Find_3", "Find_4", "Find_5"}},
+			{And(Like("name", "find%"), Range("created_at", nil, start)), nil},
+			{And(Like("name", "find%"), Or()), nil},
+		} {
+			users, err := Users.Find(db, test.filter)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(test.want) {
+				t.Errorf("%#v: want %v, got %v", test.filter, test.want, names)
+			}
+		}
+
+		user, err := Users.FindOne(db, Eq("email", "find3@example.com"))
+		if err != nil {
+			t.Error(err)
+		} else if user.Name != "Find_3" {
+			t.Error("want Find_3, got", user.Name)
+		}
+		if _, err := Users.FindOne(db, Eq("name", "nobody")); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+		if _, err := Users.Find(db, Eq("password", "")); err == nil {
+			t.Error("filter by password")
+		}
+
+		page, err := Users.List(db, ListOptions{Limit: 2, Filter: Like("name", "find%"), States: []int64{UserActive}})
+		if err != nil {
+			t.Error(err)
+		} else if page.Total != 3 || len(page.Items) != 2 {
+			t.Error("want 3 users, got", page.Total, len(page.Items))
+		}
+
+		if _, err := (&Role{Name: "find_role", Description: "x"}).CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		role, err := Roles.FindOne(db, And(Eq("name", "find_role"), Eq("description", "x")))
+		if err != nil {
+			t.Error(err)
+		} else if role.Name != "find_role" {
+			t.Error("want find_role, got", role.Name)
+		}
+		roles, err := Roles.Find(db, Like("name", "find%"))
+		if err != nil {
+			t.Error(err)
+		} else if len(roles) != 1 {
+			t.Error("want 1 role, got", len(roles))
+		}
+
+		for _, name := range []string{"Find_0", "Find_1"} {
+			if err := UserProfiles.Set(db, name, "find.theme", "dark"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if err := UserProfiles.Set(db, "Find_0", "find.lang", "zh"); err != nil {
+			t.Error(err)
+			return
+		}
+		profiles, err := UserProfiles.Find(db, And(Eq("name", "find.theme"), Eq("value", "dark")))
+		if err != nil {
+			t.Error(err)
+		} else if len(profiles) != 2 || profiles[0].User != "Find_0" || profiles[1].User != "Find_1" {
+			t.Error("want Find_0 and Find_1, got", profiles)
+		}
+		profile, err := UserProfiles.FindOne(db, And(Eq("usr", "Find_0"), Like("name", "find.l%")))
+		if err != nil {
+			t.Error(err)
+		} else if profile.Value != "zh" {
+			t.Error("want zh, got", profile.Value)
+		}
+		if _, err := UserProfiles.Find(db, Eq("password", "")); err == nil {
+			t.Error("filter profiles by an unknown column")
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package permissions
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrCursorInvalid - 表示分页的游标无效, 或者与当前的排序方式不匹配
+var ErrCursorInvalid = errors.New("cursor is invalid")
+
+var (
+	// DefaultListLimit ListOptions.Limit 小于等于 0 时每页的记录数
+	DefaultListLimit = 20
+
+	// MaxListLimit 每页最多的记录数
+	MaxListLimit = 1000
+)
+
+// ListOptions 是 Users.List 和 Roles.List 的分页, 排序和过滤条件
+type ListOptions struct {
+	// Limit 每页的记录数, 见 DefaultListLimit 和 MaxListLimit
+	Limit int
+	// Offset 跳过的记录数, 不能与 Cursor 同时使用
+	Offset int
+	// Cursor 上一页返回的 NextCursor, 从它之后继续列出, 翻页时排序和过滤条件应保持不变
+	Cursor string
+	// Sort 排序的列, 前面加 - 表示降序, 例如 "-created_at", 为空时按 id 升序排列。
+	// 用户可以按 id, name, state, created_at 和 updated_at 排序,
+	// 角色可以按 id, name, created_at 和 updated_at 排序
+	Sort string
+
+	// NamePrefix 名称的前缀, 不区分大小写
+	NamePrefix string
+	// Email 用户的邮箱, 只用于 Users.List
+	Email string
+	// States 用户的状态, 只用于 Users.List
+	States []int64
+	// Role 只列出拥有该角色的用户, 只用于 Users.List
+	Role string
+	// Filter 其它的过滤条件, 见 Filter
+	Filter Filter
+}
+
+// UserPage 是 Users.List 返回的一页用户
+type UserPage struct {
+	Items []*User
+	// Total 符合过滤条件的用户总数
+	Total int64
+	// NextCursor 用于获取下一页, 为空时表示没有下一页
+	NextCursor string
+}
+
+// RolePage 是 Roles.List 返回的一页角色
+type RolePage struct {
+	Items []*Role
+	// Total 符合过滤条件的角色总数
+	Total int64
+	// NextCursor 用于获取下一页, 为空时表示没有下一页
+	NextCursor string
+}
+
+type sortKind int
+
+const (
+	sortInt sortKind = iota
+	sortString
+	sortTime
+)
+
+var userSortColumns = map[string]sortKind{
+	"id":         sortInt,
+	"name":       sortString,
+	"state":      sortInt,
+	"created_at": sortTime,
+	"updated_at": sortTime,
+}
+
+var roleSortColumns = map[string]sortKind{
+	"id":         sortInt,
+	"name":       sortString,
+	"created_at": sortTime,
+	"updated_at": sortTime,
+}
+
+// listCursor 是编码在 ListOptions.Cursor 中的上一页的最后一条记录的排序值和 id
+type listCursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v,omitempty"`
+	ID    int64           `json:"i"`
+}
+
+// listQuery 生成 List 的查询条件, 排序的列相同时再按 id 排序, 以便游标可以唯一地确定位置
+type listQuery struct {
+	table  string
+	sort   string
+	column string
+	kind   sortKind
+	desc   bool
+	limit  int64
+	offset int64
+
+	filters []string
+	args    []interface{}
+
+	hasCursor   bool
+	cursorValue interface{}
+	cursorID    int64
+}
+
+func newListQuery(table string, opts ListOptions, columns map[string]sortKind) (*listQuery, error) {
+	q := &listQuery{table: table, column: "id", kind: sortInt, limit: int64(opts.Limit), offset: int64(opts.Offset)}
+	if q.limit <= 0 {
+		q.limit = int64(DefaultListLimit)
+	}
+	if q.limit > int64(MaxListLimit) {
+		q.limit = int64(MaxListLimit)
+	}
+	if q.offset < 0 {
+		q.offset = 0
+	}
+
+	column := opts.Sort
+	if strings.HasPrefix(column, "-") {
+		q.desc = true
+		column = column[1:]
+	}
+	if column != "" {
+		kind, ok := columns[column]
+		if !ok {
+			return nil, errors.New("sort column '" + column + "' is unknown")
+		}
+		q.column = column
+		q.kind = kind
+	}
+	q.sort = q.column
+	if q.desc {
+		q.sort = "-" + q.column
+	}
+
+	if opts.Cursor != "" {
+		if q.offset > 0 {
+			return nil, errors.New("offset and cursor can't be used together")
+		}
+		if err := q.decodeCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.NamePrefix != "" {
+		q.filter("LOWER("+table+".name) LIKE ? ESCAPE '!'", likePrefix(strings.ToLower(opts.NamePrefix)))
+	}
+	return q, nil
+}
+
+// likePrefix 转义 LIKE 中的通配符, 转义字符用 ! 而不用 \, 因为 MySQL 的字符串中 \ 也是转义字符
+func likePrefix(prefix string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
+}
+
+func (q *listQuery) filter(condition string, args ...interface{}) {
+	q.filters = append(q.filters, condition)
+	q.args = append(q.args, args...)
+}
+
+func (q *listQuery) filterBy(table string, columns map[string]bool, filter Filter) error {
+	condition, args, err := buildFilter(table, columns, filter)
+	if err != nil || condition == "" {
+		return err
+	}
+	q.filter("("+condition+")", args...)
+	return nil
+}
+
+// where 返回过滤条件, withCursor 为 true 时包括游标的条件
+func (q *listQuery) where(withCursor bool) (string, []interface{}) {
+	conditions := append([]string{}, q.filters...)
+	args := append([]interface{}{}, q.args...)
+	if withCursor && q.hasCursor {
+		op := " > "
+		if q.desc {
+			op = " < "
+		}
+		id := q.table + ".id"
+		if q.column == "id" {
+			conditions = append(conditions, id+op+"?")
+			args = append(args, q.cursorID)
+		} else {
+			column := q.table + "." + q.column
+			conditions = append(conditions, "("+column+op+"? OR ("+column+" = ? AND "+id+op+"?))")
+			args = append(args, q.cursorValue, q.cursorValue, q.cursorID)
+		}
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// query 返回查询一页记录的条件, 多查询一条记录用于判断是否还有下一页
+func (q *listQuery) query(dialect Dialect) (string, []interface{}) {
+	where, args := q.where(true)
+	direction := ""
+	if q.desc {
+		direction = " DESC"
+	}
+	order := " ORDER BY " + q.table + "." + q.column + direction
+	if q.column != "id" {
+		order += ", " + q.table + ".id" + direction
+	}
+	return where + order + dialect.Paginate(q.limit+1, q.offset), args
+}
+
+func (q *listQuery) encodeCursor(value interface{}, id int64) string {
+	cursor := listCursor{Sort: q.sort, ID: id}
+	if q.column != "id" {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ""
+		}
+		cursor.Value = data
+	}
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *listQuery) decodeCursor(s string) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrCursorInvalid
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != q.sort {
+		return ErrCursorInvalid
+	}
+
+	q.hasCursor = true
+	q.cursorID = cursor.ID
+	if q.column == "id" {
+		return nil
+	}
+	switch q.kind {
+	case sortInt:
+		var value int64
+		err = json.Unmarshal(cursor.Value, &value)
+		q.cursorValue = value
+	case sortString:
+		var value string
+		err = json.Unmarshal(cursor.Value, &value)
+		q.cursorValue = value
+	case sortTime:
+		var value time.Time
+		err = json.Unmarshal(cursor.Value, &value)
+		// DAO 写入的是本地时间, SQLite 按字符串比较时间, 所以这里也转换为本地时间
+		q.cursorValue = value.Local()
+	}
+	if err != nil {
+		return ErrCursorInvalid
+	}
+	return nil
+}
+
+// count 返回 from 中符合条件的记录的个数
+func (s *Store) count(ctx context.Context, db Executor, from string, args ...interface{}) (int64, error) {
+	queryString, err := s.rebind("SELECT count(*) FROM " + from)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = db.QueryRowContext(ctx, queryString, args...).Scan(&count)
+	return count, err
+}
+
+func userSortValue(user *User, column string) interface{} {
+	switch column {
+	case "name":
+		return user.Name
+	case "state":
+		return user.State
+	case "created_at":
+		return user.CreatedAt
+	case "updated_at":
+		return user.UpdatedAt
+	default:
+		return user.ID
+	}
+}
+
+// ListContext 按 opts 分页列出用户
+func (self *users) ListContext(ctx context.Context, db Executor, opts ListOptions) (*UserPage, error) {
+	q, err := newListQuery("tpt_users", opts, userSortColumns)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Email != "" {
+		q.filter("tpt_users.email = ?", NormalizeEmail(opts.Email))
+	}
+	if len(opts.States) > 0 {
+		args := make([]interface{}, 0, len(opts.States))
+		for _, state := range opts.States {
+			args = append(args, state)
+		}
+		q.filter("tpt_users.state IN (?"+strings.Repeat(", ?", len(opts.States)-1)+")", args...)
+	}
+	if opts.Role != "" {
+		q.filter("EXISTS (SELECT * FROM "+self.store.TableName("tpt_user_roles")+" tpt_user_roles JOIN "+self.store.TableName("tpt_roles")+" tpt_roles ON tpt_user_roles.role_id = tpt_roles.id"+
+			" WHERE tpt_user_roles.user_id = tpt_users.id AND tpt_roles.deleted_at IS NULL AND tpt_roles.name = ?)", opts.Role)
+	}
+	if err := q.filterBy("tpt_users", userFilterColumns, opts.Filter); err != nil {
+		return nil, err
+	}
+
+	where, args := q.where(false)
+	total, err := self.store.count(ctx, db, self.from()+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, args := q.query(self.store.dialect)
+	items, err := self.QueryWithContext(ctx, db, queryString, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UserPage{Items: items, Total: total}
+	if int64(len(items)) > q.limit {
+		page.Items = items[:q.limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = q.encodeCursor(userSortValue(last, q.column), last.ID)
+	}
+	return page, nil
+}
+
+// List 使用 context.Background() 调用 ListContext
+func (self *users) List(db Executor, opts ListOptions) (*UserPage, error) {
+	return self.ListContext(context.Background(), db, opts)
+}
+
+func roleSortValue(role *Role, column string) interface{} {
+	switch column {
+	case "name":
+		return role.Name
+	case "created_at":
+		return role.CreatedAt
+	case "updated_at":
+		return role.UpdatedAt
+	default:
+		return role.ID
+	}
+}
+
+// ListContext 按 opts 分页列出角色, opts 中只有 NamePrefix 可用于过滤角色
+func (self *roles) ListContext(ctx context.Context, db Executor, opts ListOptions) (*RolePage, error) {
+	if opts.Email != "" || len(opts.States) > 0 || opts.Role != "" {
+		return nil, errors.New("roles can't be filtered by email, state or role")
+	}
+	q, err := newListQuery("tpt_roles", opts, roleSortColumns)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.filterBy("tpt_roles", roleFilterColumns, opts.Filter); err != nil {
+		return nil, err
+	}
+
+	where, args := q.where(false)
+	total, err := self.store.count(ctx, db, self.from()+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	queryString, args := q.query(self.store.dialect)
+	items, err := self.QueryWithContext(ctx, db, queryString, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &RolePage{Items: items, Total: total}
+	if int64(len(items)) > q.limit {
+		page.Items = items[:q.limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = q.encodeCursor(roleSortValue(last, q.column), last.ID)
+	}
+	return page, nil
+}
+
+// List 使用 context.Background() 调用 ListContext
+func (self *roles) List(db Executor, opts ListOptions) (*RolePage, error) {
+	return self.ListContext(context.Background(), db, opts)
+}
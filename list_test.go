@@ -0,0 +1,210 @@
+package permissions
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		role := &Role{Name: "list_admin"}
+		if _, err := role.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i := 0; i < 25; i++ {
+			user := &User{Name: fmt.Sprintf("List_%02d", i), Email: fmt.Sprintf("list%02d@example.com", i), State: UserActive}
+			if i%5 == 0 {
+				user.State = UserDisabled
+			}
+			if _, err := user.CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%10 == 0 {
+				if err := Users.AddRole(db, user.ID, role.ID); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+		deleted := &User{Name: "list_deleted"}
+		if _, err := deleted.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := deleted.DeleteIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := (&User{Name: "listx"}).CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		names := func(users []*User) []string {
+			var s []string
+			for _, u := range users {
+				s = append(s, u.Name)
+			}
+			return s
+		}
+
+		// limit 和 offset
+		page, err := Users.List(db, ListOptions{Limit: 10, Offset: 20, Sort: "name", NamePrefix: "list_"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if page.Total != 25 || len(page.Items) != 5 || page.Items[0].Name != "List_20" || page.NextCursor != "" {
+			t.Error("offset page is wrong,", page.Total, names(page.Items), page.NextCursor)
+		}
+
+		// 用游标按名称降序遍历
+		var all []string
+		opts := ListOptions{Limit: 7, Sort: "-name", NamePrefix: "LIST_"}
+		for i := 0; ; i++ {
+			page, err := Users.List(db, opts)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if page.Total != 25 {
+				t.Error("want total 25, got", page.Total)
+			}
+			all = append(all, names(page.Items)...)
+			if page.NextCursor == "" {
+				break
+			}
+			if i > 5 {
+				t.Error("cursor doesn't end")
+				return
+			}
+			opts.Cursor = page.NextCursor
+		}
+		if len(all) != 25 || all[0] != "List_24" || all[24] != "List_00" {
+			t.Error("cursor walk is wrong,", all)
+		}
+
+		// 按时间排序时游标也要能区分相同的时间
+		var ids []int64
+		opts = ListOptions{Limit: 4, Sort: "created_at", NamePrefix: "list_"}
+		for {
+			page, err := Users.List(db, opts)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, u := range page.Items {
+				ids = append(ids, u.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		if len(ids) != 25 {
+			t.Error("want 25 users, got", len(ids))
+		}
+		seen := map[int64]bool{}
+		for _, id := range ids {
+			if seen[id] {
+				t.Error("user", id, "is listed twice")
+			}
+			seen[id] = true
+		}
+
+		// 过滤条件
+		for _, test := range []struct {
+			opts  ListOptions
+			total int64
+		}{
+			{ListOptions{NamePrefix: "list_1"}, 10},
+			{ListOptions{Email: "LIST03@example.com"}, 1},
+			{ListOptions{NamePrefix: "list_", States: []int64{UserDisabled}}, 5},
+			{ListOptions{States: []int64{UserDisabled, UserActive}, NamePrefix: "list"}, 26},
+			{ListOptions{Role: "list_admin"}, 3},
+			{ListOptions{Role: "list_admin", States: []int64{UserDisabled}}, 3},
+		} {
+			page, err := Users.List(db, test.opts)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			count := test.total
+			if count > int64(DefaultListLimit) {
+				count = int64(DefaultListLimit)
+			}
+			if page.Total != test.total || int64(len(page.Items)) != count {
+				t.Errorf("%+v: want %d, got %d %v", test.opts, test.total, page.Total, names(page.Items))
+			}
+		}
+
+		// 前缀中的 _ 不是通配符
+		page, err = Users.List(db, ListOptions{NamePrefix: "list_"})
+		if err != nil {
+			t.Error(err)
+		} else if page.Total != 25 {
+			t.Error("want 25, got", page.Total)
+		}
+		page, err = Users.WithDeleted().List(db, ListOptions{NamePrefix: "list_"})
+		if err != nil {
+			t.Error(err)
+		} else if page.Total != 26 {
+			t.Error("want 26, got", page.Total)
+		}
+
+		if _, err := Users.List(db, ListOptions{Sort: "password"}); err == nil {
+			t.Error("sort by password")
+		}
+		if _, err := Users.List(db, ListOptions{Cursor: "abc"}); err != ErrCursorInvalid {
+			t.Error("want ErrCursorInvalid, got", err)
+		}
+		page, err = Users.List(db, ListOptions{Limit: 2, Sort: "name"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Users.List(db, ListOptions{Sort: "-name", Cursor: page.NextCursor}); err != ErrCursorInvalid {
+			t.Error("want ErrCursorInvalid, got", err)
+		}
+		if _, err := Users.List(db, ListOptions{Offset: 2, Sort: "name", Cursor: page.NextCursor}); err == nil {
+			t.Error("offset and cursor are used together")
+		}
+	})
+}
+
+func TestListRoles(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		for i := 0; i < 5; i++ {
+			if _, err := (&Role{Name: fmt.Sprintf("lr_%d", i)}).CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		page, err := Roles.List(db, ListOptions{Limit: 2, Sort: "-id", NamePrefix: "lr_"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if page.Total != 5 || len(page.Items) != 2 || page.Items[0].Name != "lr_4" || page.NextCursor == "" {
+			t.Error("first page is wrong,", page.Total, len(page.Items), page.NextCursor)
+			return
+		}
+		page, err = Roles.List(db, ListOptions{Limit: 2, Sort: "-id", NamePrefix: "lr_", Cursor: page.NextCursor})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(page.Items) != 2 || page.Items[0].Name != "lr_2" || page.Items[1].Name != "lr_1" {
+			t.Error("second page is wrong")
+		}
+
+		if _, err := Roles.List(db, ListOptions{Role: "lr_1"}); err == nil {
+			t.Error("filter roles by role")
+		}
+	})
+}
@@ -0,0 +1,444 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// migrationFiles 是各个数据库的迁移脚本, 目录名为 Dialect.Name(), 文件名为 "版本号_名称.sql",
+// 以后对表结构的修改都应该以新版本的形式添加到这里, 不要修改已经发布的脚本。
+// MySQL 的 DDL 会隐式提交事务, 所以 MySQL 的每个脚本只能有一条语句, 其它数据库的脚本与它保持相同的版本号。
+// 第一行为 "-- option: 选项名" 的脚本只在对应的选项打开时执行, 见 Migration.Option。
+//
+// 脚本是 text/template 的模板, 表名写为 {{table "tpt_users"}}, 索引和约束的名称以及限定列名中的
+// 表名写为 {{name "tpt_users_name_uq"}}, 执行时按 StoreOptions 展开为实际的名称。
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var (
+	// MigrationLockTimeout 迁移锁被持有超过这个时间后被视为失效,
+	// 用于持有锁的实例在迁移过程中崩溃的情况
+	MigrationLockTimeout = 10 * time.Minute
+
+	// migrationLockInterval 等待其它实例释放迁移锁时的轮询间隔
+	migrationLockInterval = time.Second
+)
+
+// Migration 是一个版本的迁移脚本
+type Migration struct {
+	Version int64
+	Name    string
+	// Option 不为空时只在 Store 的对应选项打开时执行, 可以是 unique_email(见 UniqueEmail)
+	// 或 unique_phone(见 UniquePhone)。选项关闭时它不会被记录为已执行, 以后打开选项后
+	// 再次调用 Migrate 时会执行它, 但关闭选项不会撤销已经执行过的迁移。
+	Option string
+	SQL    string
+}
+
+// MigrationState 是一个版本的迁移的执行情况
+type MigrationState struct {
+	Version   int64
+	Name      string
+	Option    string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+const migrationOptionPrefix = "-- option:"
+
+// baselineVersion 是引入迁移之前的表结构的最后一个版本, 0001 到 0004 各创建一张表
+const baselineVersion = 4
+
+// Migrations 返回 dialect 的所有迁移脚本, 按版本号从小到大排列
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", dialect.Name())
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, errors.New("migrations of '" + dialect.Name() + "' isn't found")
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		p := strings.IndexByte(name, '_')
+		if p < 0 {
+			return nil, errors.New("migration '" + entry.Name() + "' is invalid, version is missing")
+		}
+		version, err := strconv.ParseInt(name[:p], 10, 64)
+		if err != nil {
+			return nil, errors.New("migration '" + entry.Name() + "' is invalid, " + err.Error())
+		}
+		data, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration := Migration{Version: version, Name: name[p+1:], SQL: string(data)}
+		if strings.HasPrefix(migration.SQL, migrationOptionPrefix) {
+			line := migration.SQL
+			if p := strings.IndexByte(line, '\n'); p >= 0 {
+				line = line[:p]
+			}
+			migration.Option = strings.TrimSpace(strings.TrimPrefix(line, migrationOptionPrefix))
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// DialectOf 按 db 的驱动猜测它的 Dialect
+func DialectOf(db *sql.DB) (Dialect, bool) {
+	driver := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+	switch {
+	case strings.Contains(driver, "pq.") || strings.Contains(driver, "pgx") || strings.Contains(driver, "postgres"):
+		return Postgres, true
+	case strings.Contains(driver, "mysql"):
+		return MySQL, true
+	case strings.Contains(driver, "sqlite"):
+		return SQLite, true
+	case strings.Contains(driver, "mssql") || strings.Contains(driver, "sqlserver"):
+		return SQLServer, true
+	case strings.Contains(driver, "godror") || strings.Contains(driver, "oci8") || strings.Contains(driver, "oracle"):
+		return Oracle, true
+	default:
+		return nil, false
+	}
+}
+
+// Migrate 在 db 上执行所有还没有执行过的迁移, 数据库的类型由 DialectOf 决定,
+// 见 Store.Migrate
+func Migrate(ctx context.Context, db *sql.DB) error {
+	dialect, ok := DialectOf(db)
+	if !ok {
+		return fmt.Errorf("dialect of driver '%T' is unknown", db.Driver())
+	}
+	return NewStore(db, dialect, nil).Migrate(ctx)
+}
+
+// MigrationStatus 返回 db 上每个版本的迁移的执行情况, 见 Store.MigrationStatus
+func MigrationStatus(ctx context.Context, db *sql.DB) ([]MigrationState, error) {
+	dialect, ok := DialectOf(db)
+	if !ok {
+		return nil, fmt.Errorf("dialect of driver '%T' is unknown", db.Driver())
+	}
+	return NewStore(db, dialect, nil).MigrationStatus(ctx)
+}
+
+// Migrate 按版本号依次执行还没有执行过的迁移, 每个迁移在一个事务中执行,
+// 执行过的版本记录在 tpt_schema_migrations 中。迁移期间持有 tpt_schema_lock 中的锁,
+// 多个实例同时启动时只有一个会执行迁移, 其它的等待它完成。
+//
+// MySQL 和 Oracle 的 DDL 会隐式提交事务, 事务只对 DML 有效: 迁移失败时已经执行的 DDL
+// 不会回滚, 而版本没有被记录。所以 MySQL 的每个迁移只有一条语句, 失败时要么没有生效,
+// 要么只差版本记录(此时在 tpt_schema_migrations 中补上这个版本即可); Oracle 的迁移失败后
+// 需要对照脚本手工清理已经执行的语句再重新迁移。
+//
+// 0001 到 0004 是引入迁移之前的表结构, 已经有这些表的数据库会跳过它们, 直接从 0005 开始升级。
+func (s *Store) Migrate(ctx context.Context) error {
+	migrations, err := Migrations(s.dialect)
+	if err != nil {
+		return err
+	}
+	if err := s.createMigrationTables(ctx); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		// 在有迁移之前建立的数据库已经有了 0001 到 0004 中的表, 从 0005 开始执行
+		exists, err := s.tableExists(ctx, "tpt_user_roles")
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if !exists || migration.Version > baselineVersion {
+				break
+			}
+			if err := s.execWith(ctx, s.db, "INSERT INTO "+s.TableName("tpt_schema_migrations")+"(version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now()); err != nil {
+				return err
+			}
+			applied[migration.Version] = time.Now()
+		}
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok || !s.migrationEnabled(migration) {
+			continue
+		}
+		if err := s.applyMigration(ctx, migration); err != nil {
+			return fmt.Errorf("apply migration %d_%s fail, %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus 返回每个版本的迁移的执行情况, 按版本号从小到大排列
+func (s *Store) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := Migrations(s.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.createMigrationTables(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		appliedAt, ok := applied[migration.Version]
+		states = append(states, MigrationState{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Option:    migration.Option,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return states, nil
+}
+
+// migrationEnabled 判断迁移的选项是否打开, 未知的选项总是关闭的
+func (s *Store) migrationEnabled(migration Migration) bool {
+	switch migration.Option {
+	case "":
+		return true
+	case "unique_email":
+		return s.uniqueEmail()
+	case "unique_phone":
+		return s.uniquePhone()
+	default:
+		return false
+	}
+}
+
+func (s *Store) applyMigration(ctx context.Context, migration Migration) error {
+	return s.InTx(ctx, func(tx *sql.Tx) error {
+		sqlString, err := s.migrationSQL(migration)
+		if err != nil {
+			return err
+		}
+		for _, statement := range splitStatements(sqlString) {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return s.execWith(ctx, tx, "INSERT INTO "+s.TableName("tpt_schema_migrations")+"(version, name, applied_at) VALUES (?, ?, ?)",
+			migration.Version, migration.Name, time.Now())
+	})
+}
+
+// migrationSQL 按 StoreOptions 展开迁移脚本中的表名和其它名称, 见 migrationFiles
+func (s *Store) migrationSQL(migration Migration) (string, error) {
+	tmpl, err := template.New(migration.Name).Funcs(template.FuncMap{
+		"table": s.TableName,
+		"name":  s.objectName,
+	}).Parse(migration.SQL)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (s *Store) appliedMigrations(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM "+s.TableName("tpt_schema_migrations"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.Time
+	}
+	return applied, rows.Err()
+}
+
+// createMigrationTables 创建 tpt_schema_migrations 和 tpt_schema_lock, 有的数据库
+// 不支持 CREATE TABLE IF NOT EXISTS, 所以先查询一次, 表不存在时才创建。
+func (s *Store) createMigrationTables(ctx context.Context) error {
+	integer := "bigint"
+	if s.dialect == Oracle {
+		integer = "number(19)"
+	}
+	timestamp := s.dialect.TimestampType()
+
+	for _, table := range []struct {
+		name string
+		ddl  string
+	}{
+		{"tpt_schema_migrations", "CREATE TABLE " + s.TableName("tpt_schema_migrations") + " (version " + integer + " NOT NULL PRIMARY KEY," +
+			" name varchar(200), applied_at " + timestamp + ")"},
+		{"tpt_schema_lock", "CREATE TABLE " + s.TableName("tpt_schema_lock") + " (id " + integer + " NOT NULL PRIMARY KEY," +
+			" owner varchar(200), locked_at " + timestamp + ")"},
+	} {
+		exists, err := s.tableExists(ctx, table.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, table.ddl); err != nil {
+			// 可能是另一个实例同时创建了它
+			if exists, e := s.tableExists(ctx, table.name); e == nil && exists {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExists 判断表是否存在, table 为默认的表名。只有数据库报告表不存在时才返回 false,
+// 其它错误(如没有权限或连接断开)原样返回, 以免把已有的表当作不存在。
+func (s *Store) tableExists(ctx context.Context, table string) (bool, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM "+s.TableName(table)).Scan(&count)
+	if err == nil {
+		return true, nil
+	}
+	if isUndefinedTable(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// lockMigrations 在 tpt_schema_lock 中插入 id 为 1 的记录作为锁, 插入失败说明锁被其它实例持有,
+// 此时等待它被释放或超过 MigrationLockTimeout 后失效。持有锁期间会定期更新锁的时间,
+// 所以执行时间超过 MigrationLockTimeout 的迁移不会被其它实例抢走锁。
+func (s *Store) lockMigrations(ctx context.Context) (func(), error) {
+	hostname, _ := os.Hostname()
+	owner := hostname + ":" + strconv.Itoa(os.Getpid()) + ":" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	released := 0
+	for {
+		err := s.execWith(ctx, s.db, "INSERT INTO "+s.TableName("tpt_schema_lock")+"(id, owner, locked_at) VALUES (1, ?, ?)", owner, time.Now())
+		if err == nil {
+			return s.keepMigrationLock(owner), nil
+		}
+
+		var count int64
+		queryString, e := s.rebind("SELECT count(*) FROM " + s.TableName("tpt_schema_lock") + " WHERE id = 1")
+		if e != nil {
+			return nil, e
+		}
+		if e := s.db.QueryRowContext(ctx, queryString).Scan(&count); e != nil {
+			return nil, err
+		}
+		if count == 0 {
+			// 锁在插入失败之后刚好被释放了, 立即重试; 多次这样时说明插入失败另有原因
+			if released++; released < 3 {
+				continue
+			}
+			return nil, err
+		}
+		released = 0
+
+		// 删除失效的锁后立即重试
+		deleteString, e := s.rebind("DELETE FROM " + s.TableName("tpt_schema_lock") + " WHERE id = 1 AND locked_at < ?")
+		if e != nil {
+			return nil, e
+		}
+		result, e := s.db.ExecContext(ctx, deleteString, time.Now().Add(-MigrationLockTimeout))
+		if e != nil {
+			return nil, e
+		}
+		if deleted, e := result.RowsAffected(); e == nil && deleted > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockInterval):
+		}
+	}
+}
+
+// keepMigrationLock 每隔 MigrationLockTimeout 的三分之一更新一次锁的时间, 返回的函数停止更新并释放锁
+func (s *Store) keepMigrationLock(owner string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(MigrationLockTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.execWith(ctx, s.db, "UPDATE "+s.TableName("tpt_schema_lock")+" SET locked_at = ? WHERE id = 1 AND owner = ?", time.Now(), owner)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+		s.execWith(context.Background(), s.db, "DELETE FROM "+s.TableName("tpt_schema_lock")+" WHERE id = 1 AND owner = ?", owner)
+	}
+}
+
+// splitStatements 将迁移脚本按行尾的分号拆分为单独的语句, 并去掉只有注释的行,
+// 因为有的驱动(如 MySQL 和 Oracle)一次只能执行一条语句。
+func splitStatements(script string) []string {
+	var statements []string
+	var buf strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			buf.WriteString(strings.TrimSuffix(strings.TrimRight(line, " \t\r"), ";"))
+			statements = append(statements, buf.String())
+			buf.Reset()
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+	if rest := strings.TrimSpace(buf.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
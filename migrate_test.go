@@ -0,0 +1,277 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(`-- comment
+CREATE TABLE a
+(
+  id bigint
+);
+
+CREATE INDEX a_id ON a (id);
+INSERT INTO a(id) VALUES (1)`)
+	want := []string{
+		"CREATE TABLE a\n(\n  id bigint\n)",
+		"CREATE INDEX a_id ON a (id)",
+		"INSERT INTO a(id) VALUES (1)",
+	}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("want %q, got %q", want, statements)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	prefixed := NewStore(nil, nil, &StoreOptions{TablePrefix: "app_", Schema: "auth"})
+	var versions []int64
+	for _, dialect := range []Dialect{Postgres, MySQL, SQLite, SQLServer, Oracle} {
+		migrations, err := Migrations(dialect)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var dialectVersions []int64
+		for _, migration := range migrations {
+			dialectVersions = append(dialectVersions, migration.Version)
+
+			// 所有的名称都应该写成模板, 以便按 StoreOptions 展开
+			sqlString, err := prefixed.migrationSQL(migration)
+			if err != nil {
+				t.Error(dialect.Name(), migration.Name, err)
+			} else if strings.Contains(sqlString, "tpt_") {
+				t.Error(dialect.Name(), migration.Name, "has a name that isn't a template")
+			}
+		}
+		if versions == nil {
+			versions = dialectVersions
+		} else if !reflect.DeepEqual(versions, dialectVersions) {
+			t.Errorf("%s: want %v, got %v", dialect.Name(), versions, dialectVersions)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		dialect, _ := DialectByName(*driverName)
+		store := NewStore(db, dialect, nil)
+
+		// dbTest 已经执行过迁移, 再次执行时什么也不做
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- store.Migrate(ctx)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(states) == 0 {
+			t.Error("migrations are missing")
+		}
+		for _, state := range states {
+			if state.Option != "" {
+				if state.Applied != store.migrationEnabled(Migration{Option: state.Option}) {
+					t.Error("optional migration is wrong", state)
+				}
+			} else if !state.Applied || state.AppliedAt.IsZero() {
+				t.Error("migration isn't applied", state)
+			}
+		}
+
+		// 打开选项后再次迁移时执行对应的迁移
+		uniqueStore := NewStore(db, dialect, &StoreOptions{UniqueEmail: true})
+		if err := uniqueStore.Migrate(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+		states, err = uniqueStore.MigrationStatus(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, state := range states {
+			if state.Applied != (state.Option != "unique_phone" || UniquePhone) {
+				t.Error("optional migration is wrong", state)
+			}
+		}
+
+		// 失效的锁
+		err = store.execWith(ctx, db, "INSERT INTO tpt_schema_lock(id, owner, locked_at) VALUES (1, ?, ?)",
+			"crashed", time.Now().Add(-2*MigrationLockTimeout))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := store.Migrate(ctx); err != nil {
+			t.Error(err)
+		}
+
+		// 被其它实例持有的锁
+		err = store.execWith(ctx, db, "INSERT INTO tpt_schema_lock(id, owner, locked_at) VALUES (1, ?, ?)",
+			"other", time.Now())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		if err := store.Migrate(timeoutCtx); err != context.DeadlineExceeded {
+			t.Error("want context.DeadlineExceeded, got", err)
+		}
+		if err := store.execWith(ctx, db, "DELETE FROM tpt_schema_lock"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 持有锁的时间超过 MigrationLockTimeout 时锁不会失效
+		oldTimeout := MigrationLockTimeout
+		MigrationLockTimeout = 90 * time.Millisecond
+		defer func() {
+			MigrationLockTimeout = oldTimeout
+		}()
+		unlock, err := store.lockMigrations(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(3 * MigrationLockTimeout)
+		timeoutCtx, cancel = context.WithTimeout(ctx, 150*time.Millisecond)
+		defer cancel()
+		if _, err := store.lockMigrations(timeoutCtx); err != context.DeadlineExceeded {
+			t.Error("want context.DeadlineExceeded, got", err)
+		}
+		unlock()
+		if err := store.Migrate(ctx); err != nil {
+			t.Error(err)
+		}
+
+		// 查询失败不等于表不存在, 连接不可用时不会重新执行迁移
+		closed, err := sql.Open(*driverName, *dataSourceName)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		closed.Close()
+		if exists, err := NewStore(closed, dialect, nil).tableExists(ctx, "tpt_users"); err == nil {
+			t.Error("want an error, got", exists)
+		}
+		if err := NewStore(closed, dialect, nil).Migrate(ctx); err == nil {
+			t.Error("want an error, got nil")
+		}
+		if exists, err := store.tableExists(ctx, "tpt_users"); err != nil || !exists {
+			t.Error("want true, got", exists, err)
+		}
+		if exists, err := store.tableExists(ctx, "tpt_not_exists"); err != nil || exists {
+			t.Error("want false, got", exists, err)
+		}
+	})
+}
+
+func TestMigrateBaseline(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		dialect, _ := DialectByName(*driverName)
+		store := NewStore(db, dialect, nil)
+
+		// 模拟在有迁移之前用 0001 到 0004 的表结构建立的数据库
+		if err := dropSchema(db, store); err != nil {
+			t.Error(err)
+			return
+		}
+		migrations, err := Migrations(dialect)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, migration := range migrations {
+			if migration.Version > baselineVersion {
+				break
+			}
+			baseline, err := store.migrationSQL(migration)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, statement := range splitStatements(baseline) {
+				if _, err := db.Exec(statement); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+		if err := store.execWith(ctx, db, "INSERT INTO tpt_users(name, password, state) VALUES (?, ?, ?)", "old_user", "", UserActive); err != nil {
+			t.Error(err)
+			return
+		}
+		// 旧版本的属性按用户名关联用户, 找不到用户的属性中可能有同名的
+		for _, usr := range []string{"old_user", "unknown_user", "unknown_user2"} {
+			if err := store.execWith(ctx, db, "INSERT INTO tpt_user_profiles(usr, name, value) VALUES (?, ?, ?)", usr, "theme", "dark"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if err := store.Migrate(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+		states, err := store.MigrationStatus(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(states) != len(migrations) {
+			t.Error("want", len(migrations), "migrations, got", len(states))
+		}
+		for _, state := range states {
+			if !state.Applied && store.migrationEnabled(Migration{Option: state.Option}) {
+				t.Error("migration isn't applied", state)
+			}
+		}
+
+		user, err := store.Users.FindByName(db, "old_user")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.FailedAttempts != 0 || !user.DeletedAt.IsZero() {
+			t.Error("new columns are wrong,", user.FailedAttempts, user.DeletedAt)
+		}
+		if _, err := store.Users.CreateIt(db, &User{Name: "new_user"}); err != nil {
+			t.Error(err)
+		}
+
+		// 迁移按用户名填充了属性的 user_id, 找不到用户的属性保留为 NULL
+		if value, err := store.Profiles.Get(db, "old_user", "theme"); err != nil || value != "dark" {
+			t.Error("want dark, got", value, err)
+		}
+		var orphans int64
+		if err := db.QueryRow("SELECT count(*) FROM tpt_user_profiles WHERE user_id IS NULL").Scan(&orphans); err != nil {
+			t.Error(err)
+		} else if orphans != 2 {
+			t.Error("want 2 orphans, got", orphans)
+		}
+	})
+}
@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_roles"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name varchar(50),
+  permission_keys text,
+  description varchar(200),
+  created_at datetime(6) DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at datetime(6) DEFAULT CURRENT_TIMESTAMP(6),
+  CONSTRAINT {{name "tpt_roles_name_uq"}} UNIQUE (name)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_users"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name varchar(50),
+  password varchar(200),
+  phone varchar(50),
+  email varchar(100),
+  description varchar(200),
+  created_at datetime(6) DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at datetime(6) DEFAULT CURRENT_TIMESTAMP(6),
+  state integer NOT NULL DEFAULT 0,
+  CONSTRAINT {{name "tpt_users_name_uq"}} UNIQUE (name)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_profiles"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  usr varchar(50),
+  name varchar(50),
+  value text NOT NULL,
+  created_at datetime(6),
+  updated_at datetime(6)
+);
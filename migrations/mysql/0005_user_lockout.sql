@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN failed_attempts integer NOT NULL DEFAULT 0,
+  ADD COLUMN locked_until datetime(6);
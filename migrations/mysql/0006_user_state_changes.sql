@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_user_state_changes"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id bigint NOT NULL,
+  from_state integer NOT NULL,
+  to_state integer NOT NULL,
+  operator varchar(50),
+  reason varchar(200),
+  created_at datetime(6),
+  CONSTRAINT {{name "tpt_user_state_changes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
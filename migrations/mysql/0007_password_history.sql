@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_password_history"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id bigint NOT NULL,
+  password varchar(200) NOT NULL,
+  created_at datetime(6),
+  CONSTRAINT {{name "tpt_user_password_history_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+CREATE TABLE {{table "tpt_user_totp"}}
+(
+  user_id bigint NOT NULL PRIMARY KEY,
+  secret varchar(100) NOT NULL,
+  confirmed boolean NOT NULL DEFAULT false,
+  last_counter bigint NOT NULL DEFAULT 0,
+  created_at datetime(6),
+  updated_at datetime(6),
+  CONSTRAINT {{name "tpt_user_totp_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
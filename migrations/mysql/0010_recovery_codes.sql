@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_recovery_codes"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id bigint NOT NULL,
+  code_hash varchar(100) NOT NULL,
+  created_at datetime(6),
+  CONSTRAINT {{name "tpt_user_recovery_codes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
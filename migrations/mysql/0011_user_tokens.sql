@@ -0,0 +1,14 @@
+CREATE TABLE {{table "tpt_user_tokens"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id bigint NOT NULL,
+  name varchar(100),
+  token_hash varchar(100) NOT NULL,
+  scopes text,
+  expires_at datetime(6),
+  last_used_at datetime(6),
+  created_at datetime(6),
+  CONSTRAINT {{name "tpt_user_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_user_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
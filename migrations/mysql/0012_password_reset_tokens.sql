@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_password_reset_tokens"}}
+(
+  id bigint NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id bigint NOT NULL,
+  token_hash varchar(100) NOT NULL,
+  expires_at datetime(6) NOT NULL,
+  used_at datetime(6),
+  created_at datetime(6),
+  CONSTRAINT {{name "tpt_password_reset_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_password_reset_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
@@ -0,0 +1,6 @@
+-- MySQL 不支持部分索引, 用只在记录没有被删除时才有值的生成列代替, 已删除的记录不占用名称
+ALTER TABLE {{table "tpt_roles"}}
+  ADD COLUMN deleted_at datetime(6),
+  ADD COLUMN live_name varchar(50) AS (CASE WHEN deleted_at IS NULL THEN name END),
+  DROP INDEX {{name "tpt_roles_name_uq"}},
+  ADD CONSTRAINT {{name "tpt_roles_name_uq"}} UNIQUE (live_name);
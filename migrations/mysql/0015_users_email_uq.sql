@@ -0,0 +1,4 @@
+-- option: unique_email
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN live_email varchar(100) AS (CASE WHEN deleted_at IS NULL AND email <> '' THEN email END),
+  ADD CONSTRAINT {{name "tpt_users_email_uq"}} UNIQUE (live_email);
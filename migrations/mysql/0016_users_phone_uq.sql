@@ -0,0 +1,4 @@
+-- option: unique_phone
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN live_phone varchar(50) AS (CASE WHEN deleted_at IS NULL AND phone <> '' THEN phone END),
+  ADD CONSTRAINT {{name "tpt_users_phone_uq"}} UNIQUE (live_phone);
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN last_login_at datetime(6),
+  ADD COLUMN last_login_ip varchar(50);
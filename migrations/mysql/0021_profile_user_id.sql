@@ -0,0 +1,4 @@
+ALTER TABLE {{table "tpt_user_profiles"}}
+  ADD COLUMN user_id bigint,
+  ADD CONSTRAINT {{name "tpt_user_profiles_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+-- 按 usr 中的用户名填充 user_id, 找不到用户的属性的 user_id 仍为 NULL, 它们不能再通过用户访问
+UPDATE {{table "tpt_user_profiles"}} SET user_id =
+  (SELECT u.id FROM {{table "tpt_users"}} u WHERE u.name = {{name "tpt_user_profiles"}}.usr AND u.deleted_at IS NULL);
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_user_profiles"}}
+  DROP INDEX {{name "tpt_user_profiles_usr_name_uq"}},
+  ADD CONSTRAINT {{name "tpt_user_profiles_user_id_name_uq"}} UNIQUE (user_id, name);
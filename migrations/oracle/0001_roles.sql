@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_roles"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  name varchar2(50),
+  permission_keys clob,
+  description varchar2(200),
+  created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+  updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT {{name "tpt_roles_name_uq"}} UNIQUE (name)
+);
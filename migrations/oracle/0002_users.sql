@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_users"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  name varchar2(50),
+  password varchar2(200),
+  phone varchar2(50),
+  email varchar2(100),
+  description varchar2(200),
+  created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+  updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+  state number(10) DEFAULT 0 NOT NULL,
+  CONSTRAINT {{name "tpt_users_name_uq"}} UNIQUE (name)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_profiles"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  usr varchar2(50),
+  name varchar2(50),
+  value clob NOT NULL,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone
+);
@@ -0,0 +1,8 @@
+CREATE TABLE {{table "tpt_user_roles"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  role_id number(19) NOT NULL REFERENCES {{table "tpt_roles"}} (id) ON DELETE CASCADE,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone
+);
@@ -0,0 +1,4 @@
+ALTER TABLE {{table "tpt_users"}} ADD (
+  failed_attempts number(10) DEFAULT 0 NOT NULL,
+  locked_until timestamp with time zone
+);
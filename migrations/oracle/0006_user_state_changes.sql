@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_user_state_changes"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  from_state number(10) NOT NULL,
+  to_state number(10) NOT NULL,
+  operator varchar2(50),
+  reason varchar2(200),
+  created_at timestamp with time zone
+);
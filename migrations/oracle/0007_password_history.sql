@@ -0,0 +1,7 @@
+CREATE TABLE {{table "tpt_user_password_history"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  password varchar2(200) NOT NULL,
+  created_at timestamp with time zone
+);
@@ -0,0 +1,4 @@
+ALTER TABLE {{table "tpt_users"}} ADD (
+  password_changed_at timestamp with time zone,
+  must_change_password number(1) DEFAULT 0 NOT NULL
+);
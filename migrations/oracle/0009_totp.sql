@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_totp"}}
+(
+  user_id number(19) NOT NULL PRIMARY KEY REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  secret varchar2(100) NOT NULL,
+  confirmed number(1) DEFAULT 0 NOT NULL,
+  last_counter number(19) DEFAULT 0 NOT NULL,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone
+);
@@ -0,0 +1,7 @@
+CREATE TABLE {{table "tpt_user_recovery_codes"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  code_hash varchar2(100) NOT NULL,
+  created_at timestamp with time zone
+);
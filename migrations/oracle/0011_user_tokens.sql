@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_user_tokens"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  name varchar2(100),
+  token_hash varchar2(100) NOT NULL,
+  scopes clob,
+  expires_at timestamp with time zone,
+  last_used_at timestamp with time zone,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_tokens_token_hash_uq"}} UNIQUE (token_hash)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_password_reset_tokens"}}
+(
+  id number(19) GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  user_id number(19) NOT NULL REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE,
+  token_hash varchar2(100) NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  used_at timestamp with time zone,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_reset_tokens_token_hash_uq"}} UNIQUE (token_hash)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE {{table "tpt_roles"}} ADD (deleted_at timestamp with time zone);
+
+-- Oracle 不支持部分索引, 所有列都为 NULL 的行不会进入索引, 所以用 CASE 代替, 已删除的记录不占用名称
+ALTER TABLE {{table "tpt_roles"}} DROP CONSTRAINT {{name "tpt_roles_name_uq"}};
+CREATE UNIQUE INDEX {{name "tpt_roles_name_uq"}} ON {{table "tpt_roles"}} (CASE WHEN deleted_at IS NULL THEN name END);
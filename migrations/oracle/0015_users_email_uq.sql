@@ -0,0 +1,3 @@
+-- option: unique_email
+-- Oracle 中空字符串就是 NULL, 所以不需要检查 email <> ''
+CREATE UNIQUE INDEX {{name "tpt_users_email_uq"}} ON {{table "tpt_users"}} (CASE WHEN deleted_at IS NULL THEN email END);
@@ -0,0 +1,3 @@
+-- option: unique_phone
+-- Oracle 中空字符串就是 NULL, 所以不需要检查 phone <> ''
+CREATE UNIQUE INDEX {{name "tpt_users_phone_uq"}} ON {{table "tpt_users"}} (CASE WHEN deleted_at IS NULL THEN phone END);
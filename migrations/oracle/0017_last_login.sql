@@ -0,0 +1,4 @@
+ALTER TABLE {{table "tpt_users"}} ADD (
+  last_login_at timestamp with time zone,
+  last_login_ip varchar2(50)
+);
@@ -0,0 +1,3 @@
+-- 以前同一个属性可能有多条记录, 只保留最后写入的一条
+DELETE FROM {{table "tpt_user_profiles"}} WHERE id NOT IN
+  (SELECT id FROM (SELECT max(id) AS id FROM {{table "tpt_user_profiles"}} GROUP BY usr, name) latest);
@@ -0,0 +1 @@
+ALTER TABLE {{table "tpt_user_profiles"}} ADD (version number(10) DEFAULT 0 NOT NULL);
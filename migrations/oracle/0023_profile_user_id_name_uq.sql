@@ -0,0 +1,6 @@
+ALTER TABLE {{table "tpt_user_profiles"}} DROP CONSTRAINT {{name "tpt_user_profiles_usr_name_uq"}};
+-- Oracle 的唯一约束不允许部分为 NULL 的重复键, 所以用函数索引排除 user_id 为 NULL 的属性,
+-- 所有列都为 NULL 的行不会进入索引
+CREATE UNIQUE INDEX {{name "tpt_user_profiles_user_id_name_uq"}} ON {{table "tpt_user_profiles"}} (
+  CASE WHEN user_id IS NOT NULL THEN user_id END,
+  CASE WHEN user_id IS NOT NULL THEN name END);
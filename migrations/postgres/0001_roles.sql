@@ -0,0 +1,11 @@
+CREATE TABLE {{table "tpt_roles"}}
+(
+  id serial,
+  name character varying(50),
+  permission_keys character varying(40000),
+  description character varying(200),
+  created_at timestamp with time zone DEFAULT now(),
+  updated_at timestamp with time zone DEFAULT now(),
+  CONSTRAINT {{name "tpt_roles_pkey"}} PRIMARY KEY (id),
+  CONSTRAINT {{name "tpt_roles_name_uq"}} UNIQUE (name)
+);
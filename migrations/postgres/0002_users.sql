@@ -0,0 +1,14 @@
+CREATE TABLE {{table "tpt_users"}}
+(
+  id serial,
+  name character varying(50),
+  password character varying(200),
+  phone character varying(50),
+  email character varying(100),
+  description character varying(200),
+  created_at timestamp with time zone DEFAULT now(),
+  updated_at timestamp with time zone DEFAULT now(),
+  state integer NOT NULL DEFAULT 0,
+  CONSTRAINT {{name "tpt_users_pkey"}} PRIMARY KEY (id),
+  CONSTRAINT {{name "tpt_users_name_uq"}} UNIQUE (name)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_user_profiles"}}
+(
+  id serial,
+  usr character varying(50),
+  name character varying(50),
+  value character varying(10000) NOT NULL,
+  created_at timestamp without time zone,
+  updated_at timestamp without time zone,
+  CONSTRAINT {{name "tpt_user_profiles_pkey"}} PRIMARY KEY (id)
+);
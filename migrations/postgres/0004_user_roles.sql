@@ -0,0 +1,15 @@
+CREATE TABLE {{table "tpt_user_roles"}}
+(
+  id serial,
+  user_id bigint NOT NULL,
+  role_id bigint NOT NULL,
+  created_at timestamp without time zone,
+  updated_at timestamp without time zone,
+  CONSTRAINT {{name "tpt_user_roles_pkey"}} PRIMARY KEY (id),
+  CONSTRAINT {{name "tpt_user_roles_role_id_fkey"}} FOREIGN KEY (role_id)
+      REFERENCES {{table "tpt_roles"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE,
+  CONSTRAINT {{name "tpt_user_roles_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_user_state_changes"}}
+(
+  id serial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  from_state integer NOT NULL,
+  to_state integer NOT NULL,
+  operator character varying(50),
+  reason character varying(200),
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_state_changes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_user_password_history"}}
+(
+  id serial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  password character varying(200) NOT NULL,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_password_history_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN password_changed_at timestamp with time zone,
+  ADD COLUMN must_change_password boolean NOT NULL DEFAULT false;
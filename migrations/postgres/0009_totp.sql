@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_user_totp"}}
+(
+  user_id bigint NOT NULL,
+  secret character varying(100) NOT NULL,
+  confirmed boolean NOT NULL DEFAULT false,
+  last_counter bigint NOT NULL DEFAULT 0,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_totp_pkey"}} PRIMARY KEY (user_id),
+  CONSTRAINT {{name "tpt_user_totp_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_user_recovery_codes"}}
+(
+  id serial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  code_hash character varying(100) NOT NULL,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_recovery_codes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
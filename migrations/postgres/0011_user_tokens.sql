@@ -0,0 +1,15 @@
+CREATE TABLE {{table "tpt_user_tokens"}}
+(
+  id serial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  name character varying(100),
+  token_hash character varying(100) NOT NULL,
+  scopes character varying(10000),
+  expires_at timestamp with time zone,
+  last_used_at timestamp with time zone,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_user_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_user_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
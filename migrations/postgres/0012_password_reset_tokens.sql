@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_password_reset_tokens"}}
+(
+  id serial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  token_hash character varying(100) NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  used_at timestamp with time zone,
+  created_at timestamp with time zone,
+  CONSTRAINT {{name "tpt_password_reset_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_password_reset_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
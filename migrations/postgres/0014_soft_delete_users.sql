@@ -0,0 +1,5 @@
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN deleted_at timestamp with time zone;
+
+-- 已删除的记录不占用名称
+ALTER TABLE {{table "tpt_users"}} DROP CONSTRAINT {{name "tpt_users_name_uq"}};
+CREATE UNIQUE INDEX {{name "tpt_users_name_uq"}} ON {{table "tpt_users"}} (name) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}}
+  ADD COLUMN last_login_at timestamp with time zone,
+  ADD COLUMN last_login_ip character varying(50);
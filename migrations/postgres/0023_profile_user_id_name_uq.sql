@@ -0,0 +1,2 @@
+ALTER TABLE {{table "tpt_user_profiles"}} DROP CONSTRAINT {{name "tpt_user_profiles_usr_name_uq"}};
+ALTER TABLE {{table "tpt_user_profiles"}} ADD CONSTRAINT {{name "tpt_user_profiles_user_id_name_uq"}} UNIQUE (user_id, name);
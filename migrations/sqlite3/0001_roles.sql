@@ -0,0 +1,12 @@
+-- SQLite 不能删除表上的约束, 所以唯一性约束都用索引, 以便以后的迁移可以修改它们
+CREATE TABLE {{table "tpt_roles"}}
+(
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name character varying(50),
+  permission_keys character varying(40000),
+  description character varying(200),
+  created_at datetime DEFAULT CURRENT_TIMESTAMP,
+  updated_at datetime DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX {{name "tpt_roles_name_uq"}} ON {{table "tpt_roles"}} (name);
@@ -0,0 +1,14 @@
+CREATE TABLE {{table "tpt_users"}}
+(
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name character varying(50),
+  password character varying(200),
+  phone character varying(50),
+  email character varying(100),
+  description character varying(200),
+  created_at datetime DEFAULT CURRENT_TIMESTAMP,
+  updated_at datetime DEFAULT CURRENT_TIMESTAMP,
+  state integer NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX {{name "tpt_users_name_uq"}} ON {{table "tpt_users"}} (name);
@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_profiles"}}
+(
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  usr character varying(50),
+  name character varying(50),
+  value character varying(10000) NOT NULL,
+  created_at datetime,
+  updated_at datetime
+);
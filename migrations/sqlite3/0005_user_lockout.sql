@@ -0,0 +1,2 @@
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN failed_attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN locked_until datetime;
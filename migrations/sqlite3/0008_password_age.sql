@@ -0,0 +1,2 @@
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN password_changed_at datetime;
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN must_change_password boolean NOT NULL DEFAULT false;
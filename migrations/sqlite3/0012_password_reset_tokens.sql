@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_password_reset_tokens"}}
+(
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id bigint NOT NULL,
+  token_hash character varying(100) NOT NULL,
+  expires_at datetime NOT NULL,
+  used_at datetime,
+  created_at datetime,
+  CONSTRAINT {{name "tpt_password_reset_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_password_reset_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) MATCH SIMPLE
+      ON UPDATE NO ACTION ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN last_login_at datetime;
+ALTER TABLE {{table "tpt_users"}} ADD COLUMN last_login_ip character varying(50);
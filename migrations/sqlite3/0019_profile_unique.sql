@@ -0,0 +1 @@
+CREATE UNIQUE INDEX {{name "tpt_user_profiles_usr_name_uq"}} ON {{table "tpt_user_profiles"}} (usr, name);
@@ -0,0 +1,2 @@
+DROP INDEX {{name "tpt_user_profiles_usr_name_uq"}};
+CREATE UNIQUE INDEX {{name "tpt_user_profiles_user_id_name_uq"}} ON {{table "tpt_user_profiles"}} (user_id, name);
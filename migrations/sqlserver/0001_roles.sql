@@ -0,0 +1,10 @@
+CREATE TABLE {{table "tpt_roles"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  name nvarchar(50),
+  permission_keys nvarchar(max),
+  description nvarchar(200),
+  created_at datetimeoffset DEFAULT SYSDATETIMEOFFSET(),
+  updated_at datetimeoffset DEFAULT SYSDATETIMEOFFSET(),
+  CONSTRAINT {{name "tpt_roles_name_uq"}} UNIQUE (name)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE {{table "tpt_users"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  name nvarchar(50),
+  password nvarchar(200),
+  phone nvarchar(50),
+  email nvarchar(100),
+  description nvarchar(200),
+  created_at datetimeoffset DEFAULT SYSDATETIMEOFFSET(),
+  updated_at datetimeoffset DEFAULT SYSDATETIMEOFFSET(),
+  state integer NOT NULL DEFAULT 0,
+  CONSTRAINT {{name "tpt_users_name_uq"}} UNIQUE (name)
+);
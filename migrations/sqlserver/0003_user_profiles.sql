@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_profiles"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  usr nvarchar(50),
+  name nvarchar(50),
+  value nvarchar(max) NOT NULL,
+  created_at datetimeoffset,
+  updated_at datetimeoffset
+);
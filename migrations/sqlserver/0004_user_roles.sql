@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_user_roles"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  user_id bigint NOT NULL,
+  role_id bigint NOT NULL,
+  created_at datetimeoffset,
+  updated_at datetimeoffset,
+  CONSTRAINT {{name "tpt_user_roles_role_id_fkey"}} FOREIGN KEY (role_id)
+      REFERENCES {{table "tpt_roles"}} (id) ON DELETE CASCADE,
+  CONSTRAINT {{name "tpt_user_roles_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}} ADD
+  failed_attempts integer NOT NULL DEFAULT 0,
+  locked_until datetimeoffset;
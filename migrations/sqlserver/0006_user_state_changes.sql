@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_user_state_changes"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  user_id bigint NOT NULL,
+  from_state integer NOT NULL,
+  to_state integer NOT NULL,
+  operator nvarchar(50),
+  reason nvarchar(200),
+  created_at datetimeoffset,
+  CONSTRAINT {{name "tpt_user_state_changes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}} ADD
+  password_changed_at datetimeoffset,
+  must_change_password bit NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+CREATE TABLE {{table "tpt_user_recovery_codes"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  user_id bigint NOT NULL,
+  code_hash nvarchar(100) NOT NULL,
+  created_at datetimeoffset,
+  CONSTRAINT {{name "tpt_user_recovery_codes_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
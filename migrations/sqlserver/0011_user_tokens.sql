@@ -0,0 +1,14 @@
+CREATE TABLE {{table "tpt_user_tokens"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  user_id bigint NOT NULL,
+  name nvarchar(100),
+  token_hash nvarchar(100) NOT NULL,
+  scopes nvarchar(max),
+  expires_at datetimeoffset,
+  last_used_at datetimeoffset,
+  created_at datetimeoffset,
+  CONSTRAINT {{name "tpt_user_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_user_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+CREATE TABLE {{table "tpt_password_reset_tokens"}}
+(
+  id bigint IDENTITY(1,1) PRIMARY KEY,
+  user_id bigint NOT NULL,
+  token_hash nvarchar(100) NOT NULL,
+  expires_at datetimeoffset NOT NULL,
+  used_at datetimeoffset,
+  created_at datetimeoffset,
+  CONSTRAINT {{name "tpt_password_reset_tokens_token_hash_uq"}} UNIQUE (token_hash),
+  CONSTRAINT {{name "tpt_password_reset_tokens_user_id_fkey"}} FOREIGN KEY (user_id)
+      REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE
+);
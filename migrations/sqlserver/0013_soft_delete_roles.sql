@@ -0,0 +1,5 @@
+ALTER TABLE {{table "tpt_roles"}} ADD deleted_at datetimeoffset;
+
+-- 已删除的记录不占用名称
+ALTER TABLE {{table "tpt_roles"}} DROP CONSTRAINT {{name "tpt_roles_name_uq"}};
+CREATE UNIQUE INDEX {{name "tpt_roles_name_uq"}} ON {{table "tpt_roles"}} (name) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+-- option: unique_email
+CREATE UNIQUE INDEX {{name "tpt_users_email_uq"}} ON {{table "tpt_users"}} (email) WHERE email IS NOT NULL AND email <> '' AND deleted_at IS NULL;
@@ -0,0 +1,2 @@
+-- option: unique_phone
+CREATE UNIQUE INDEX {{name "tpt_users_phone_uq"}} ON {{table "tpt_users"}} (phone) WHERE phone IS NOT NULL AND phone <> '' AND deleted_at IS NULL;
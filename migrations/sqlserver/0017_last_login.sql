@@ -0,0 +1,3 @@
+ALTER TABLE {{table "tpt_users"}} ADD
+  last_login_at datetimeoffset,
+  last_login_ip nvarchar(50);
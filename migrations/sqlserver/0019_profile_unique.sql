@@ -0,0 +1 @@
+ALTER TABLE {{table "tpt_user_profiles"}} ADD CONSTRAINT {{name "tpt_user_profiles_usr_name_uq"}} UNIQUE (usr, name);
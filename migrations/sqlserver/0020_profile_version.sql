@@ -0,0 +1 @@
+ALTER TABLE {{table "tpt_user_profiles"}} ADD version integer NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE {{table "tpt_user_profiles"}} ADD user_id bigint
+  CONSTRAINT {{name "tpt_user_profiles_user_id_fkey"}} REFERENCES {{table "tpt_users"}} (id) ON DELETE CASCADE;
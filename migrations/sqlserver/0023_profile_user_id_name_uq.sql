@@ -0,0 +1,4 @@
+ALTER TABLE {{table "tpt_user_profiles"}} DROP CONSTRAINT {{name "tpt_user_profiles_usr_name_uq"}};
+-- SQL Server 的唯一约束认为 NULL 彼此相等, 所以用过滤索引排除 user_id 为 NULL 的属性
+CREATE UNIQUE INDEX {{name "tpt_user_profiles_user_id_name_uq"}} ON {{table "tpt_user_profiles"}} (user_id, name)
+  WHERE user_id IS NOT NULL;
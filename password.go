@@ -0,0 +1,272 @@
+package permissions
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码策略中的规则名称
+const (
+	RulePasswordLength  = "length"
+	RulePasswordUpper   = "upper"
+	RulePasswordLower   = "lower"
+	RulePasswordDigit   = "digit"
+	RulePasswordSymbol  = "symbol"
+	RulePasswordHistory = "history"
+)
+
+// PasswordPolicy 代表密码策略, 零值表示不做任何限制
+type PasswordPolicy struct {
+	// MinLength 密码的最小长度
+	MinLength int
+	// RequireUpper 是否必须包含大写字母
+	RequireUpper bool
+	// RequireLower 是否必须包含小写字母
+	RequireLower bool
+	// RequireDigit 是否必须包含数字
+	RequireDigit bool
+	// RequireSymbol 是否必须包含特殊字符
+	RequireSymbol bool
+	// HistorySize 不能与最近多少个密码相同, 为 0 时不检查
+	HistorySize int
+}
+
+// Check 检查密码是否满足字符方面的规则, 返回所有不满足的规则
+func (policy *PasswordPolicy) Check(password string) []string {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	length := 0
+	for _, c := range password {
+		length++
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+
+	var rules []string
+	if length < policy.MinLength {
+		rules = append(rules, RulePasswordLength)
+	}
+	if policy.RequireUpper && !hasUpper {
+		rules = append(rules, RulePasswordUpper)
+	}
+	if policy.RequireLower && !hasLower {
+		rules = append(rules, RulePasswordLower)
+	}
+	if policy.RequireDigit && !hasDigit {
+		rules = append(rules, RulePasswordDigit)
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		rules = append(rules, RulePasswordSymbol)
+	}
+	return rules
+}
+
+// PasswordPolicyError 表示密码不满足密码策略, Rules 列出了所有不满足的规则
+type PasswordPolicyError struct {
+	Rules []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password violates the policy: " + strings.Join(e.Rules, ", ")
+}
+
+var (
+	// DefaultPasswordPolicy 创建用户和修改密码时使用的密码策略, 默认只要求新密码不能与最近 5 个密码相同
+	DefaultPasswordPolicy = PasswordPolicy{HistorySize: 5}
+
+	// PasswordHashCost 加密密码时使用的 bcrypt cost
+	PasswordHashCost = bcrypt.DefaultCost
+
+	// PasswordMaxAge 密码的有效期, 过期后登录时要求修改密码, 为 0 时永不过期
+	PasswordMaxAge time.Duration
+)
+
+// passwordExpired 判断用户的密码是否已过期, 没有记录修改时间时以创建时间为准
+func passwordExpired(user *User, now time.Time) bool {
+	if PasswordMaxAge <= 0 {
+		return false
+	}
+	changedAt := user.PasswordChangedAt
+	if changedAt.IsZero() {
+		changedAt = user.CreatedAt
+	}
+	return now.Sub(changedAt) > PasswordMaxAge
+}
+
+func hashPassword(password string) (string, error) {
+	bs, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+func isPasswordHash(s string) bool {
+	return strings.HasPrefix(s, "$2a$") ||
+		strings.HasPrefix(s, "$2b$") ||
+		strings.HasPrefix(s, "$2y$")
+}
+
+// checkPassword 比较密码, 兼容以前以明文保存的密码
+func checkPassword(stored, password string) bool {
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return stored != "" && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// hashNewPassword 按密码策略检查新密码, 通过后返回加密后的密码,
+// userID 为 0 时表示新建用户, 不检查历史密码。
+func (self *users) hashNewPassword(ctx context.Context, db Executor, userID int64, password string) (string, error) {
+	rules := DefaultPasswordPolicy.Check(password)
+	if userID != 0 && DefaultPasswordPolicy.HistorySize > 0 {
+		used, err := self.isPasswordUsed(ctx, db, userID, password, DefaultPasswordPolicy.HistorySize)
+		if err != nil {
+			return "", err
+		}
+		if used {
+			rules = append(rules, RulePasswordHistory)
+		}
+	}
+	if len(rules) > 0 {
+		return "", &PasswordPolicyError{Rules: rules}
+	}
+	return hashPassword(password)
+}
+
+func (self *users) isPasswordUsed(ctx context.Context, db Executor, userID int64, password string, count int) (bool, error) {
+	queryString := "SELECT password FROM " + self.store.TableName("tpt_user_password_history") + " WHERE user_id = ? ORDER BY id DESC"
+	queryString, err := self.store.rebind(queryString)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := db.QueryContext(ctx, queryString, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for i := 0; i < count && rows.Next(); i++ {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		if checkPassword(hash, password) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func (self *users) addPasswordHistory(ctx context.Context, db Executor, userID int64, hash string, now time.Time) error {
+	insertString := "INSERT INTO " + self.store.TableName("tpt_user_password_history") + "(user_id, password, created_at) VALUES (?, ?, ?)"
+	insertString, err := self.store.rebind(insertString)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, insertString, userID, hash, now)
+	return err
+}
+
+// ChangePasswordContext 校验旧密码后修改为新密码
+func (self *users) ChangePasswordContext(ctx context.Context, db Executor, userID int64, oldPassword, newPassword string) error {
+	user, err := self.FindByIDContext(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if !checkPassword(user.Password, oldPassword) {
+		return ErrPasswordIncorrect
+	}
+	return self.SetPasswordContext(ctx, db, userID, newPassword)
+}
+
+// ChangePassword 使用 context.Background() 调用 ChangePasswordContext
+func (self *users) ChangePassword(db Executor, userID int64, oldPassword, newPassword string) error {
+	return self.ChangePasswordContext(context.Background(), db, userID, oldPassword, newPassword)
+}
+
+// SetPasswordContext 按密码策略设置用户的新密码, 记入历史密码并清除必须修改密码的标志
+func (self *users) SetPasswordContext(ctx context.Context, db Executor, userID int64, password string) error {
+	if 0 == userID {
+		return ThrowPrimaryKeyInvalid("tpt_users")
+	}
+
+	hash, err := self.hashNewPassword(ctx, db, userID, password)
+	if err != nil {
+		return err
+	}
+	return self.setPasswordHash(ctx, db, userID, hash)
+}
+
+// SetPassword 使用 context.Background() 调用 SetPasswordContext
+func (self *users) SetPassword(db Executor, userID int64, password string) error {
+	return self.SetPasswordContext(context.Background(), db, userID, password)
+}
+
+func (self *users) setPasswordHash(ctx context.Context, db Executor, userID int64, hash string) error {
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET password = ?, password_changed_at = ?, must_change_password = ?, updated_at = ? WHERE id = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return inTx(ctx, db, func(db Executor) error {
+		result, err := db.ExecContext(ctx, updateString, hash, now, false, now, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if 0 == rowsAffected {
+			return ErrNotUpdated
+		}
+		return self.addPasswordHistory(ctx, db, userID, hash, now)
+	})
+}
+
+// ForcePasswordChangeContext 要求用户下次登录时必须修改密码, 一般在管理员重置密码后调用
+func (self *users) ForcePasswordChangeContext(ctx context.Context, db Executor, userID int64) error {
+	if 0 == userID {
+		return ThrowPrimaryKeyInvalid("tpt_users")
+	}
+
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET must_change_password = ?, updated_at = ? WHERE id = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, updateString, true, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if 0 == rowsAffected {
+		return ErrNotUpdated
+	}
+	return nil
+}
+
+// ForcePasswordChange 使用 context.Background() 调用 ForcePasswordChangeContext
+func (self *users) ForcePasswordChange(db Executor, userID int64) error {
+	return self.ForcePasswordChangeContext(context.Background(), db, userID)
+}
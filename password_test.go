@@ -0,0 +1,236 @@
+package permissions
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:     8,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+
+	for _, test := range []struct {
+		password string
+		rules    []string
+	}{
+		{password: "Abcdef1!"},
+		{password: "密码Abcde1!"},
+		{password: "Abc1!", rules: []string{RulePasswordLength}},
+		{password: "abcdefg1!", rules: []string{RulePasswordUpper}},
+		{password: "ABCDEFG1!", rules: []string{RulePasswordLower}},
+		{password: "Abcdefgh!", rules: []string{RulePasswordDigit}},
+		{password: "Abcdefgh1", rules: []string{RulePasswordSymbol}},
+		{password: "", rules: []string{RulePasswordLength, RulePasswordUpper, RulePasswordLower, RulePasswordDigit, RulePasswordSymbol}},
+	} {
+		rules := policy.Check(test.password)
+		if !reflect.DeepEqual(rules, test.rules) {
+			t.Error(test.password, rules, test.rules)
+		}
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		old := DefaultPasswordPolicy
+		defer func() {
+			DefaultPasswordPolicy = old
+		}()
+		DefaultPasswordPolicy = PasswordPolicy{
+			MinLength:    6,
+			RequireDigit: true,
+			HistorySize:  3,
+		}
+
+		user1 := &User{
+			Name:     "pwd1",
+			Password: "abc",
+		}
+		_, err := user1.CreateIt(db)
+		if e, ok := err.(*PasswordPolicyError); !ok {
+			t.Error("want PasswordPolicyError, got", err)
+		} else if !reflect.DeepEqual(e.Rules, []string{RulePasswordLength, RulePasswordDigit}) {
+			t.Error(e.Rules)
+		}
+
+		user1.Password = "pwd1_pwd1"
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if user1.Password == "pwd1_pwd1" {
+			t.Error("password isn't hashed")
+		}
+		if _, err := Authenticate(db, "pwd1", "pwd1_pwd1"); err != nil {
+			t.Error(err)
+		}
+
+		for _, password := range []string{"pwd1_pwd2", "pwd1_pwd3", "pwd1_pwd4"} {
+			if err := Users.SetPassword(db, user1.ID, password); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if _, err := Authenticate(db, "pwd1", "pwd1_pwd4"); err != nil {
+			t.Error(err)
+		}
+
+		for _, password := range []string{"pwd1_pwd2", "pwd1_pwd3", "pwd1_pwd4"} {
+			err := Users.SetPassword(db, user1.ID, password)
+			if e, ok := err.(*PasswordPolicyError); !ok {
+				t.Error(password, "want PasswordPolicyError, got", err)
+			} else if !reflect.DeepEqual(e.Rules, []string{RulePasswordHistory}) {
+				t.Error(password, e.Rules)
+			}
+		}
+
+		// 超出历史记录个数的密码可以再次使用
+		user2, err := Users.FindByID(db, user1.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		user2.Password = "pwd1_pwd1"
+		if err := user2.UpdateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "pwd1", "pwd1_pwd1"); err != nil {
+			t.Error(err)
+		}
+
+		// 密码没有变化时不检查密码策略
+		user2.Description = "pwd1_descr"
+		if err := user2.UpdateIt(db); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestPasswordHistory(t *testing.T) {
+	if DefaultPasswordPolicy.HistorySize != 5 {
+		t.Error("want 5 passwords in history by default, got", DefaultPasswordPolicy.HistorySize)
+	}
+
+	dbTest(t, func(db *sql.DB) {
+		user1 := &User{
+			Name:     "history1",
+			Password: "history1_pwd",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.SetPassword(db, user1.ID, "history1_pwd"); err == nil {
+			t.Error("want PasswordPolicyError, got nil")
+		}
+
+		// 历史密码写入失败时用户不会被创建或修改
+		if _, err := db.Exec("DROP TABLE tpt_user_password_history"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := (&User{Name: "history2", Password: "history2_pwd"}).CreateIt(db); err == nil {
+			t.Error("want an error, got nil")
+		}
+		if _, err := Users.FindByName(db, "history2"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		// 不检查历史密码, 以便修改进行到写入历史密码
+		old := DefaultPasswordPolicy
+		defer func() {
+			DefaultPasswordPolicy = old
+		}()
+		DefaultPasswordPolicy = PasswordPolicy{}
+		user1.Name = "history3"
+		user1.Password = "history3_pwd"
+		if err := user1.UpdateIt(db); err == nil {
+			t.Error("want an error, got nil")
+		}
+		if _, err := Users.FindByName(db, "history1"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		old := PasswordMaxAge
+		defer func() {
+			PasswordMaxAge = old
+		}()
+
+		user1 := &User{
+			Name:     "expiry1",
+			Password: "expiry1_pwd",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if user1.PasswordChangedAt.IsZero() {
+			t.Error("user1.PasswordChangedAt.IsZero()")
+		}
+
+		user, err := Authenticate(db, "expiry1", "expiry1_pwd")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.MustChangePassword {
+			t.Error("password isn't expired")
+		}
+
+		PasswordMaxAge = time.Nanosecond
+		time.Sleep(time.Millisecond)
+		user, err = Authenticate(db, "expiry1", "expiry1_pwd")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !user.MustChangePassword {
+			t.Error("password is expired")
+		}
+		PasswordMaxAge = time.Hour
+
+		if err := Users.ChangePassword(db, user1.ID, "bad", "expiry1_new"); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+
+		if err := Users.ForcePasswordChange(db, user1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		user, err = Authenticate(db, "expiry1", "expiry1_pwd")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !user.MustChangePassword {
+			t.Error("password change is required")
+		}
+
+		if err := Users.ChangePassword(db, user1.ID, "expiry1_pwd", "expiry1_new"); err != nil {
+			t.Error(err)
+			return
+		}
+		user, err = Authenticate(db, "expiry1", "expiry1_new")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if user.MustChangePassword {
+			t.Error("password is changed")
+		}
+		if !user.PasswordChangedAt.After(user1.PasswordChangedAt) {
+			t.Error(user.PasswordChangedAt, user1.PasswordChangedAt)
+		}
+	})
+}
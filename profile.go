@@ -0,0 +1,210 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// profileOwner 返回按用户名查找属性所有者的条件, 已删除的用户的属性会被保留, 但不能再被访问
+func (s *Store) profileOwner() string {
+	return "user_id = (SELECT id FROM " + s.TableName("tpt_users") + " WHERE name = ? AND deleted_at IS NULL)"
+}
+
+// profileOwnerID 查找属性所有者的 ID, 用户不存在时返回 ErrUserNotFound
+func (s *Store) profileOwnerID(ctx context.Context, db Executor, user string) (int64, error) {
+	queryString, err := s.rebind("SELECT id FROM " + s.TableName("tpt_users") + " WHERE name = ? AND deleted_at IS NULL")
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.QueryRowContext(ctx, queryString, user).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	return id, err
+}
+
+// resolveOwner 补全属性所有者的 UserID 和 User, User 不为空时按用户名查找, 否则按 UserID 查找
+func (self *userProfiles) resolveOwner(ctx context.Context, db Executor, value *UserProfile) error {
+	if value.User != "" {
+		id, err := self.store.profileOwnerID(ctx, db, value.User)
+		if err != nil {
+			return err
+		}
+		value.UserID = id
+		return nil
+	}
+
+	user, err := self.store.Users.FindByIDContext(ctx, db, value.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	value.User = user.Name
+	return nil
+}
+
+// GetContext 读取用户的一个属性, 属性没有设置时返回声明中的默认值(见 RegisterProfileKey),
+// 没有声明默认值时返回 sql.ErrNoRows
+func (self *userProfiles) GetContext(ctx context.Context, db Executor, user, name string) (string, error) {
+	key, ok := LookupProfileKey(name)
+	if !ok && ProfileStrict {
+		return "", ErrProfileKeyUnknown
+	}
+	value, err := self.get(ctx, db, user, name)
+	if err == sql.ErrNoRows && ok && key.Default != "" {
+		return key.Default, nil
+	}
+	return value, err
+}
+
+// Get 使用 context.Background() 调用 GetContext
+func (self *userProfiles) Get(db Executor, user, name string) (string, error) {
+	return self.GetContext(context.Background(), db, user, name)
+}
+
+func (self *userProfiles) get(ctx context.Context, db Executor, user, name string) (string, error) {
+	queryString, err := self.store.rebind("SELECT value FROM " + self.store.TableName("tpt_user_profiles") + " WHERE " + self.store.profileOwner() + " AND name = ?")
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	err = db.QueryRowContext(ctx, queryString, user, name).Scan(&value)
+	return value, err
+}
+
+// SetContext 设置用户的一个属性, 属性不存在时创建它, 属性有声明时值必须符合声明。
+//
+// 数据库支持 upsert 时(见 Dialect.Upsert)用一条语句插入或更新, 否则先删除再插入,
+// 两种方式都在一个事务中完成并将版本加 1。不在插入失败后的同一个事务中再更新,
+// 因为在 Postgres 的事务中语句失败后整个事务都不能再使用。
+func (self *userProfiles) SetContext(ctx context.Context, db Executor, user, name, value string) error {
+	if err := checkProfile(name, value); err != nil {
+		return err
+	}
+
+	userID, err := self.store.profileOwnerID(ctx, db, user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	table := self.store.TableName("tpt_user_profiles")
+	columns := []string{"user_id", "name", "usr", "value", "version", "created_at", "updated_at"}
+	upsertString := self.store.dialect.Upsert(table, columns[:2], columns, []string{"usr", "value", "updated_at"})
+	set := func(db Executor) error {
+		if upsertString != "" {
+			// 新插入的记录的版本为 0, 下面加 1 后与更新的情况一致
+			if err := self.store.execWith(ctx, db, upsertString, userID, name, user, value, 0, now, now); err != nil {
+				return toDuplicateError("tpt_user_profiles", err)
+			}
+			return self.store.execWith(ctx, db, "UPDATE "+table+" SET version = version + 1 WHERE user_id = ? AND name = ?", userID, name)
+		}
+
+		queryString, err := self.store.rebind("SELECT version, created_at FROM " + table + " WHERE user_id = ? AND name = ?")
+		if err != nil {
+			return err
+		}
+		var version int64
+		var createdAt sql.NullTime
+		err = db.QueryRowContext(ctx, queryString, userID, name).Scan(&version, &createdAt)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if !createdAt.Valid {
+			createdAt = sql.NullTime{Time: now, Valid: true}
+		}
+		if err := self.store.execWith(ctx, db, "DELETE FROM "+table+" WHERE user_id = ? AND name = ?", userID, name); err != nil {
+			return err
+		}
+		err = self.store.execWith(ctx, db, "INSERT INTO "+table+"(user_id, name, usr, value, version, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			userID, name, user, value, version+1, createdAt, now)
+		if err != nil {
+			return toDuplicateError("tpt_user_profiles", err)
+		}
+		return nil
+	}
+
+	for retried := false; ; retried = true {
+		err := inTx(ctx, db, set)
+		// 另一个连接同时插入了它, 事务是这里开启的时可以整个重试一次
+		if _, ok := err.(*DuplicateError); ok && !retried && startsTx(db) {
+			continue
+		}
+		return err
+	}
+}
+
+// Set 使用 context.Background() 调用 SetContext
+func (self *userProfiles) Set(db Executor, user, name, value string) error {
+	return self.SetContext(context.Background(), db, user, name, value)
+}
+
+// DeleteContext 删除用户的一个属性, 属性不存在时返回 ErrNotDeleted
+func (self *userProfiles) DeleteContext(ctx context.Context, db Executor, user, name string) error {
+	deleteString, err := self.store.rebind("DELETE FROM " + self.store.TableName("tpt_user_profiles") + " WHERE " + self.store.profileOwner() + " AND name = ?")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, deleteString, user, name)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotDeleted
+	}
+	return nil
+}
+
+// Delete 使用 context.Background() 调用 DeleteContext
+func (self *userProfiles) Delete(db Executor, user, name string) error {
+	return self.DeleteContext(context.Background(), db, user, name)
+}
+
+// AllContext 读取用户的所有属性, 包括没有设置但声明了默认值的属性
+func (self *userProfiles) AllContext(ctx context.Context, db Executor, user string) (map[string]string, error) {
+	queryString, err := self.store.rebind("SELECT name, value FROM " + self.store.TableName("tpt_user_profiles") + " WHERE " + self.store.profileOwner())
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, queryString, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	profileKeysLock.RLock()
+	defer profileKeysLock.RUnlock()
+	for name, key := range profileKeys {
+		if _, ok := values[name]; !ok && key.Default != "" {
+			values[name] = key.Default
+		}
+	}
+	return values, nil
+}
+
+// All 使用 context.Background() 调用 AllContext
+func (self *userProfiles) All(db Executor, user string) (map[string]string, error) {
+	return self.AllContext(context.Background(), db, user)
+}
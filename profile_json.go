@@ -0,0 +1,220 @@
+package permissions
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrProfileConflict - 表示属性已被其他人修改, 调用者应重新读取后再修改
+	ErrProfileConflict = errors.New("profile is modified by others")
+
+	// ErrProfilePathNotFound - 表示 JSON pointer 指向的值不存在
+	ErrProfilePathNotFound = errors.New("json pointer isn't found in profile")
+)
+
+// ProfilePatchRetries 不检查版本的 Patch 被其他人抢先修改时最多重试的次数,
+// 超过后返回 ErrProfileConflict
+var ProfilePatchRetries = 5
+
+// GetDocumentContext 读取 JSON 类型的属性, 返回 pointer(RFC 6901 的 JSON pointer) 指向的子文档和属性的版本,
+// pointer 为空时返回整个文档。属性没有设置时版本为 0。
+func (self *userProfiles) GetDocumentContext(ctx context.Context, db Executor, user, name, pointer string) (json.RawMessage, int64, error) {
+	value, version, err := self.getWithVersion(ctx, db, user, name)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return nil, 0, err
+		}
+		if value, err = self.GetContext(ctx, db, user, name); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if pointer == "" {
+		return json.RawMessage(value), version, nil
+	}
+	doc, err := decodeJSON([]byte(value))
+	if err != nil {
+		return nil, 0, err
+	}
+	sub, err := resolveJSONPointer(doc, pointer)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return nil, 0, err
+	}
+	return json.RawMessage(data), version, nil
+}
+
+// GetDocument 使用 context.Background() 调用 GetDocumentContext
+func (self *userProfiles) GetDocument(db Executor, user, name, pointer string) (json.RawMessage, int64, error) {
+	return self.GetDocumentContext(context.Background(), db, user, name, pointer)
+}
+
+// PatchContext 按 RFC 7386(JSON merge patch) 修改 JSON 类型的属性, 返回新的版本。
+//
+// version 是调用者读到的版本, 与数据库中的版本不一致时返回 ErrProfileConflict,
+// 属性没有设置时版本为 0; version 小于 0 时不检查版本, 总是在最新的文档上合并。
+func (self *userProfiles) PatchContext(ctx context.Context, db Executor, user, name string, patch []byte, version int64) (int64, error) {
+	patchDoc, err := decodeJSON(patch)
+	if err != nil {
+		return 0, err
+	}
+
+	for retries := 0; ; retries++ {
+		value, current, err := self.getWithVersion(ctx, db, user, name)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, err
+		}
+		// 版本列的默认值为 0, 所以不能用版本判断属性是否存在
+		exists := err == nil
+		if version >= 0 && version != current {
+			return 0, ErrProfileConflict
+		}
+
+		var doc interface{}
+		if exists {
+			if doc, err = decodeJSON([]byte(value)); err != nil {
+				return 0, err
+			}
+		}
+		data, err := json.Marshal(mergePatch(doc, patchDoc))
+		if err != nil {
+			return 0, err
+		}
+		if err := checkProfile(name, string(data)); err != nil {
+			return 0, err
+		}
+
+		err = self.compareAndSet(ctx, db, user, name, string(data), exists, current)
+		if err == nil {
+			return current + 1, nil
+		}
+		if err != ErrProfileConflict || version >= 0 || retries >= ProfilePatchRetries {
+			return 0, err
+		}
+		// 不检查版本时, 被其他人抢先修改了就重新读取后再合并
+	}
+}
+
+// Patch 使用 context.Background() 调用 PatchContext
+func (self *userProfiles) Patch(db Executor, user, name string, patch []byte, version int64) (int64, error) {
+	return self.PatchContext(context.Background(), db, user, name, patch, version)
+}
+
+func (self *userProfiles) getWithVersion(ctx context.Context, db Executor, user, name string) (string, int64, error) {
+	queryString, err := self.store.rebind("SELECT value, version FROM " + self.store.TableName("tpt_user_profiles") + " WHERE " + self.store.profileOwner() + " AND name = ?")
+	if err != nil {
+		return "", 0, err
+	}
+
+	var value string
+	var version int64
+	err = db.QueryRowContext(ctx, queryString, user, name).Scan(&value, &version)
+	return value, version, err
+}
+
+// compareAndSet 在版本为 version 时更新属性, exists 为 false 时表示属性还不存在, 此时插入它
+func (self *userProfiles) compareAndSet(ctx context.Context, db Executor, user, name, value string, exists bool, version int64) error {
+	now := time.Now()
+	if !exists {
+		userID, err := self.store.profileOwnerID(ctx, db, user)
+		if err != nil {
+			return err
+		}
+		insertString, err := self.store.rebind("INSERT INTO " + self.store.TableName("tpt_user_profiles") + "(user_id, usr, name, value, version, created_at, updated_at) VALUES (?, ?, ?, ?, 1, ?, ?)")
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, insertString, userID, user, name, value, now, now)
+		if _, ok := toDuplicateError("tpt_user_profiles", err).(*DuplicateError); ok {
+			return ErrProfileConflict
+		}
+		return err
+	}
+
+	updateString, err := self.store.rebind("UPDATE " + self.store.TableName("tpt_user_profiles") + " SET value = ?, version = version + 1, updated_at = ? WHERE " + self.store.profileOwner() + " AND name = ? AND version = ?")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, updateString, value, now, user, name, version)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrProfileConflict
+	}
+	return nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergePatch 按 RFC 7386 将 patch 合并到 target 中
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// resolveJSONPointer 按 RFC 6901 查找 pointer 指向的值
+func resolveJSONPointer(doc interface{}, pointer string) (interface{}, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.New("json pointer '" + pointer + "' is invalid")
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = unescape.Replace(token)
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			value, ok := v[token]
+			if !ok {
+				return nil, ErrProfilePathNotFound
+			}
+			doc = value
+		case []interface{}:
+			idx, err := strconv.Atoi(token)
+			if err != nil || idx < 0 || idx >= len(v) || (len(token) > 1 && token[0] == '0') {
+				return nil, ErrProfilePathNotFound
+			}
+			doc = v[idx]
+		default:
+			return nil, ErrProfilePathNotFound
+		}
+	}
+	return doc, nil
+}
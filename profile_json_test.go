@@ -0,0 +1,130 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// 摘自 RFC 7386 附录 A
+	for _, test := range []struct {
+		target, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		target, _ := decodeJSON([]byte(test.target))
+		patch, _ := decodeJSON([]byte(test.patch))
+		data, err := json.Marshal(mergePatch(target, patch))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != test.result {
+			t.Errorf("%s + %s: want %s, got %s", test.target, test.patch, test.result, data)
+		}
+	}
+}
+
+func TestUserProfilePatch(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "json1", "json2") {
+			return
+		}
+		version, err := UserProfiles.Patch(db, "json1", "layout", []byte(`{"columns":2,"widgets":[{"id":"cpu"},{"id":"mem"}]}`), 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if version != 1 {
+			t.Error("want 1, got", version)
+		}
+
+		// 第二个标签页用旧的版本修改时冲突
+		if _, err := UserProfiles.Patch(db, "json1", "layout", []byte(`{"columns":3}`), 0); err != ErrProfileConflict {
+			t.Error("want ErrProfileConflict, got", err)
+		}
+
+		version, err = UserProfiles.Patch(db, "json1", "layout", []byte(`{"columns":3,"title":"ops"}`), version)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if version, err = UserProfiles.Patch(db, "json1", "layout", []byte(`{"title":null}`), -1); err != nil || version != 3 {
+			t.Error("want 3, got", version, err)
+		}
+
+		doc, version, err := UserProfiles.GetDocument(db, "json1", "layout", "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if version != 3 {
+			t.Error("want 3, got", version)
+		}
+		if string(doc) != `{"columns":3,"widgets":[{"id":"cpu"},{"id":"mem"}]}` {
+			t.Error(string(doc))
+		}
+
+		doc, _, err = UserProfiles.GetDocument(db, "json1", "layout", "/widgets/1/id")
+		if err != nil {
+			t.Error(err)
+		} else if string(doc) != `"mem"` {
+			t.Error("want \"mem\", got", string(doc))
+		}
+		if _, _, err = UserProfiles.GetDocument(db, "json1", "layout", "/widgets/2"); err != ErrProfilePathNotFound {
+			t.Error("want ErrProfilePathNotFound, got", err)
+		}
+		if _, _, err = UserProfiles.GetDocument(db, "json2", "layout", ""); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		profiles, err := UserProfiles.QueryWith(db, "WHERE usr = ? AND name = ?", "json1", "layout")
+		if err != nil || len(profiles) != 1 {
+			t.Error(profiles, err)
+			return
+		}
+		stale := *profiles[0]
+		profiles[0].Value = `{}`
+		if err := profiles[0].UpdateIt(db); err != nil {
+			t.Error(err)
+		}
+		stale.Value = `{"columns":1}`
+		if err := stale.UpdateIt(db); err != ErrProfileConflict {
+			t.Error("want ErrProfileConflict, got", err)
+		}
+
+		// 升级前写入的属性的版本是列的默认值 0
+		if err := DefaultStore.execWith(context.Background(), db, "INSERT INTO tpt_user_profiles(user_id, usr, name, value)"+
+			" SELECT id, name, 'legacy', '{\"a\":1}' FROM tpt_users WHERE name = ?", "json2"); err != nil {
+			t.Error(err)
+			return
+		}
+		if version, err := UserProfiles.Patch(db, "json2", "legacy", []byte(`{"b":2}`), 0); err != nil || version != 1 {
+			t.Error("want 1, got", version, err)
+		}
+		if version, err := UserProfiles.Patch(db, "json2", "legacy", []byte(`{"c":3}`), -1); err != nil || version != 2 {
+			t.Error("want 2, got", version, err)
+		}
+		if doc, _, err := UserProfiles.GetDocument(db, "json2", "legacy", ""); err != nil {
+			t.Error(err)
+		} else if string(doc) != `{"a":1,"b":2,"c":3}` {
+			t.Error(string(doc))
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrProfileKeyUnknown - 表示在严格模式下使用了没有声明的属性
+var ErrProfileKeyUnknown = errors.New("profile key is unknown")
+
+// ProfileType 属性值的类型
+type ProfileType int
+
+const (
+	// ProfileString 字符串
+	ProfileString ProfileType = iota
+	// ProfileInt 整数
+	ProfileInt
+	// ProfileBool 布尔值, 保存为 true 或 false
+	ProfileBool
+	// ProfileJSON JSON 文档
+	ProfileJSON
+	// ProfileEnum 枚举, 值必须是 ProfileKey.Values 中的一个
+	ProfileEnum
+)
+
+func (t ProfileType) String() string {
+	switch t {
+	case ProfileString:
+		return "string"
+	case ProfileInt:
+		return "int"
+	case ProfileBool:
+		return "bool"
+	case ProfileJSON:
+		return "json"
+	case ProfileEnum:
+		return "enum"
+	default:
+		return "unknown(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// ProfileKey 声明一个用户属性
+type ProfileKey struct {
+	Name string
+	Type ProfileType
+	// Default 属性没有设置时的值, 格式与保存在数据库中的相同
+	Default string
+	// Values 枚举类型的可选值
+	Values []string
+	// Validate 在类型检查通过后对值做进一步的校验, 可以为 nil
+	Validate func(value string) error
+}
+
+// ProfileValueError 表示属性值不符合声明
+type ProfileValueError struct {
+	Name   string
+	Value  string
+	Reason string
+}
+
+func (e *ProfileValueError) Error() string {
+	return "value '" + e.Value + "' of profile '" + e.Name + "' is invalid, " + e.Reason
+}
+
+// check 检查 value 是否符合声明
+func (key *ProfileKey) check(value string) error {
+	switch key.Type {
+	case ProfileInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return &ProfileValueError{Name: key.Name, Value: value, Reason: "it isn't a int"}
+		}
+	case ProfileBool:
+		if value != "true" && value != "false" {
+			return &ProfileValueError{Name: key.Name, Value: value, Reason: "it isn't a bool"}
+		}
+	case ProfileJSON:
+		if !json.Valid([]byte(value)) {
+			return &ProfileValueError{Name: key.Name, Value: value, Reason: "it isn't a json"}
+		}
+	case ProfileEnum:
+		found := false
+		for _, v := range key.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &ProfileValueError{Name: key.Name, Value: value, Reason: "it must be one of " + strings.Join(key.Values, ", ")}
+		}
+	}
+	if key.Validate != nil {
+		if err := key.Validate(value); err != nil {
+			return &ProfileValueError{Name: key.Name, Value: value, Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+var (
+	// ProfileStrict 为 true 时读写没有用 RegisterProfileKey 声明过的属性会返回 ErrProfileKeyUnknown
+	ProfileStrict bool
+
+	profileKeysLock sync.RWMutex
+	profileKeys     = map[string]*ProfileKey{}
+)
+
+// RegisterProfileKey 声明一个用户属性, 重复声明时后面的覆盖前面的,
+// 默认值不符合声明时返回错误。
+func RegisterProfileKey(key ProfileKey) error {
+	if key.Name == "" {
+		return errors.New("name of profile key is missing")
+	}
+	if key.Default != "" {
+		if err := key.check(key.Default); err != nil {
+			return err
+		}
+	}
+
+	profileKeysLock.Lock()
+	defer profileKeysLock.Unlock()
+	profileKeys[key.Name] = &key
+	return nil
+}
+
+// LookupProfileKey 查找属性的声明
+func LookupProfileKey(name string) (*ProfileKey, bool) {
+	profileKeysLock.RLock()
+	defer profileKeysLock.RUnlock()
+	key, ok := profileKeys[name]
+	return key, ok
+}
+
+// UnregisterProfileKey 删除属性的声明
+func UnregisterProfileKey(name string) {
+	profileKeysLock.Lock()
+	defer profileKeysLock.Unlock()
+	delete(profileKeys, name)
+}
+
+// lookupProfileKey 查找属性的声明, 严格模式下属性没有声明时返回 ErrProfileKeyUnknown
+func lookupProfileKey(name string, typ ProfileType) (*ProfileKey, error) {
+	key, ok := LookupProfileKey(name)
+	if !ok {
+		if ProfileStrict {
+			return nil, ErrProfileKeyUnknown
+		}
+		return nil, nil
+	}
+	if key.Type != typ && !(key.Type == ProfileEnum && typ == ProfileString) {
+		return nil, errors.New("profile '" + name + "' is a " + key.Type.String() + ", not a " + typ.String())
+	}
+	return key, nil
+}
+
+// checkProfile 按声明检查属性值
+func checkProfile(name, value string) error {
+	key, ok := LookupProfileKey(name)
+	if !ok {
+		if ProfileStrict {
+			return ErrProfileKeyUnknown
+		}
+		return nil
+	}
+	return key.check(value)
+}
+
+// getTyped 读取属性, 属性没有设置时返回声明中的默认值, 没有声明也没有设置时返回 sql.ErrNoRows
+func (self *userProfiles) getTyped(ctx context.Context, db Executor, user, name string, typ ProfileType) (string, error) {
+	key, err := lookupProfileKey(name, typ)
+	if err != nil {
+		return "", err
+	}
+	value, err := self.get(ctx, db, user, name)
+	if err == sql.ErrNoRows && key != nil && key.Default != "" {
+		return key.Default, nil
+	}
+	return value, err
+}
+
+func (self *userProfiles) setTyped(ctx context.Context, db Executor, user, name string, typ ProfileType, value string) error {
+	if _, err := lookupProfileKey(name, typ); err != nil {
+		return err
+	}
+	return self.SetContext(ctx, db, user, name, value)
+}
+
+// GetStringContext 读取字符串或枚举类型的属性
+func (self *userProfiles) GetStringContext(ctx context.Context, db Executor, user, name string) (string, error) {
+	return self.getTyped(ctx, db, user, name, ProfileString)
+}
+
+// GetString 使用 context.Background() 调用 GetStringContext
+func (self *userProfiles) GetString(db Executor, user, name string) (string, error) {
+	return self.GetStringContext(context.Background(), db, user, name)
+}
+
+// SetStringContext 设置字符串或枚举类型的属性
+func (self *userProfiles) SetStringContext(ctx context.Context, db Executor, user, name, value string) error {
+	return self.setTyped(ctx, db, user, name, ProfileString, value)
+}
+
+// SetString 使用 context.Background() 调用 SetStringContext
+func (self *userProfiles) SetString(db Executor, user, name, value string) error {
+	return self.SetStringContext(context.Background(), db, user, name, value)
+}
+
+// GetIntContext 读取整数类型的属性
+func (self *userProfiles) GetIntContext(ctx context.Context, db Executor, user, name string) (int64, error) {
+	value, err := self.getTyped(ctx, db, user, name, ProfileInt)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// GetInt 使用 context.Background() 调用 GetIntContext
+func (self *userProfiles) GetInt(db Executor, user, name string) (int64, error) {
+	return self.GetIntContext(context.Background(), db, user, name)
+}
+
+// SetIntContext 设置整数类型的属性
+func (self *userProfiles) SetIntContext(ctx context.Context, db Executor, user, name string, value int64) error {
+	return self.setTyped(ctx, db, user, name, ProfileInt, strconv.FormatInt(value, 10))
+}
+
+// SetInt 使用 context.Background() 调用 SetIntContext
+func (self *userProfiles) SetInt(db Executor, user, name string, value int64) error {
+	return self.SetIntContext(context.Background(), db, user, name, value)
+}
+
+// GetBoolContext 读取布尔类型的属性
+func (self *userProfiles) GetBoolContext(ctx context.Context, db Executor, user, name string) (bool, error) {
+	value, err := self.getTyped(ctx, db, user, name, ProfileBool)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// GetBool 使用 context.Background() 调用 GetBoolContext
+func (self *userProfiles) GetBool(db Executor, user, name string) (bool, error) {
+	return self.GetBoolContext(context.Background(), db, user, name)
+}
+
+// SetBoolContext 设置布尔类型的属性
+func (self *userProfiles) SetBoolContext(ctx context.Context, db Executor, user, name string, value bool) error {
+	return self.setTyped(ctx, db, user, name, ProfileBool, strconv.FormatBool(value))
+}
+
+// SetBool 使用 context.Background() 调用 SetBoolContext
+func (self *userProfiles) SetBool(db Executor, user, name string, value bool) error {
+	return self.SetBoolContext(context.Background(), db, user, name, value)
+}
+
+// GetJSONContext 读取 JSON 类型的属性并解码到 value 中
+func (self *userProfiles) GetJSONContext(ctx context.Context, db Executor, user, name string, value interface{}) error {
+	s, err := self.getTyped(ctx, db, user, name, ProfileJSON)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(s), value)
+}
+
+// GetJSON 使用 context.Background() 调用 GetJSONContext
+func (self *userProfiles) GetJSON(db Executor, user, name string, value interface{}) error {
+	return self.GetJSONContext(context.Background(), db, user, name, value)
+}
+
+// SetJSONContext 将 value 编码为 JSON 后设置为属性值
+func (self *userProfiles) SetJSONContext(ctx context.Context, db Executor, user, name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return self.setTyped(ctx, db, user, name, ProfileJSON, string(data))
+}
+
+// SetJSON 使用 context.Background() 调用 SetJSONContext
+func (self *userProfiles) SetJSON(db Executor, user, name string, value interface{}) error {
+	return self.SetJSONContext(context.Background(), db, user, name, value)
+}
@@ -0,0 +1,141 @@
+package permissions
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestProfileSchema(t *testing.T) {
+	keys := []ProfileKey{
+		{Name: "schema.page_size", Type: ProfileInt, Default: "20", Validate: func(value string) error {
+			if len(value) > 3 {
+				return errors.New("it is too large")
+			}
+			return nil
+		}},
+		{Name: "schema.notify", Type: ProfileBool, Default: "true"},
+		{Name: "schema.theme", Type: ProfileEnum, Default: "light", Values: []string{"light", "dark"}},
+		{Name: "schema.layout", Type: ProfileJSON},
+	}
+	for _, key := range keys {
+		if err := RegisterProfileKey(key); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	defer func() {
+		for _, key := range keys {
+			UnregisterProfileKey(key.Name)
+		}
+	}()
+
+	if err := RegisterProfileKey(ProfileKey{Name: "schema.bad", Type: ProfileInt, Default: "abc"}); err == nil {
+		t.Error("register a key with invalid default")
+	}
+
+	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "schema1", "schema2") {
+			return
+		}
+		pageSize, err := UserProfiles.GetInt(db, "schema1", "schema.page_size")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if pageSize != 20 {
+			t.Error("want 20, got", pageSize)
+		}
+		if err := UserProfiles.SetInt(db, "schema1", "schema.page_size", 50); err != nil {
+			t.Error(err)
+		}
+		if pageSize, err = UserProfiles.GetInt(db, "schema1", "schema.page_size"); err != nil || pageSize != 50 {
+			t.Error("want 50, got", pageSize, err)
+		}
+		if err := UserProfiles.SetInt(db, "schema1", "schema.page_size", 5000); err == nil {
+			t.Error("set a value that fails validation")
+		} else if _, ok := err.(*ProfileValueError); !ok {
+			t.Error("want ProfileValueError, got", err)
+		}
+		if err := UserProfiles.Set(db, "schema1", "schema.page_size", "abc"); err == nil {
+			t.Error("set a string to a int profile")
+		}
+		if _, err := UserProfiles.GetBool(db, "schema1", "schema.page_size"); err == nil {
+			t.Error("read a int profile as bool")
+		}
+
+		notify, err := UserProfiles.GetBool(db, "schema1", "schema.notify")
+		if err != nil || !notify {
+			t.Error("want true, got", notify, err)
+		}
+		if err := UserProfiles.SetBool(db, "schema1", "schema.notify", false); err != nil {
+			t.Error(err)
+		}
+		if notify, err = UserProfiles.GetBool(db, "schema1", "schema.notify"); err != nil || notify {
+			t.Error("want false, got", notify, err)
+		}
+
+		if err := UserProfiles.SetString(db, "schema1", "schema.theme", "blue"); err == nil {
+			t.Error("set a value which isn't in enum")
+		}
+		if err := UserProfiles.SetString(db, "schema1", "schema.theme", "dark"); err != nil {
+			t.Error(err)
+		}
+
+		if err := UserProfiles.SetJSON(db, "schema1", "schema.layout", map[string]int{"columns": 3}); err != nil {
+			t.Error(err)
+		}
+		var layout map[string]int
+		if err := UserProfiles.GetJSON(db, "schema1", "schema.layout", &layout); err != nil {
+			t.Error(err)
+		} else if layout["columns"] != 3 {
+			t.Error("want 3, got", layout)
+		}
+		if err := UserProfiles.GetJSON(db, "schema2", "schema.layout", &layout); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		values, err := UserProfiles.All(db, "schema2")
+		if err != nil {
+			t.Error(err)
+		} else if values["schema.page_size"] != "20" || values["schema.theme"] != "light" {
+			t.Error("defaults are missing", values)
+		}
+
+		if err := UserProfiles.Set(db, "schema1", "schema.unknown", "a"); err != nil {
+			t.Error(err)
+		}
+
+		// 按 id 增删改时同样检查声明
+		if _, err := (&UserProfile{User: "schema2", Name: "schema.page_size", Value: "abc"}).CreateIt(db); err == nil {
+			t.Error("create a string to a int profile")
+		}
+		profile := &UserProfile{User: "schema2", Name: "schema.page_size", Value: "30"}
+		if _, err := profile.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		profile.Value = "abc"
+		if err := profile.UpdateIt(db); err == nil {
+			t.Error("update a string to a int profile")
+		}
+
+		old := ProfileStrict
+		ProfileStrict = true
+		defer func() {
+			ProfileStrict = old
+		}()
+		if err := UserProfiles.Set(db, "schema1", "schema.unknown", "b"); err != ErrProfileKeyUnknown {
+			t.Error("want ErrProfileKeyUnknown, got", err)
+		}
+		if _, err := UserProfiles.Get(db, "schema1", "schema.unknown"); err != ErrProfileKeyUnknown {
+			t.Error("want ErrProfileKeyUnknown, got", err)
+		}
+		if _, err := (&UserProfile{User: "schema2", Name: "schema.unknown", Value: "b"}).CreateIt(db); err != ErrProfileKeyUnknown {
+			t.Error("want ErrProfileKeyUnknown, got", err)
+		}
+		if _, err := UserProfiles.GetString(db, "schema1", "schema.theme"); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func createUsers(t *testing.T, db *sql.DB, names ...string) bool {
+	for _, name := range names {
+		if _, err := (&User{Name: name}).CreateIt(db); err != nil {
+			t.Error(err)
+			return false
+		}
+	}
+	return true
+}
+
+func TestUserProfileKeyValue(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "kv1", "kv2") {
+			return
+		}
+		if err := UserProfiles.Set(db, "kv_unknown", "theme", "dark"); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+		if _, err := UserProfiles.Get(db, "kv1", "theme"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		if err := UserProfiles.Set(db, "kv1", "theme", "dark"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := UserProfiles.Set(db, "kv1", "theme", "light"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := UserProfiles.Set(db, "kv1", "lang", "zh"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := UserProfiles.Set(db, "kv2", "theme", "dark"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		value, err := UserProfiles.Get(db, "kv1", "theme")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if value != "light" {
+			t.Error("want light, got", value)
+		}
+
+		values, err := UserProfiles.All(db, "kv1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(values) != 2 || values["theme"] != "light" || values["lang"] != "zh" {
+			t.Error("want theme=light and lang=zh, got", values)
+		}
+
+		if err := UserProfiles.Delete(db, "kv1", "theme"); err != nil {
+			t.Error(err)
+		}
+		if err := UserProfiles.Delete(db, "kv1", "theme"); err != ErrNotDeleted {
+			t.Error("want ErrNotDeleted, got", err)
+		}
+		if _, err := UserProfiles.Get(db, "kv1", "theme"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+		if value, err := UserProfiles.Get(db, "kv2", "theme"); err != nil || value != "dark" {
+			t.Error("want dark, got", value, err)
+		}
+	})
+}
+
+func TestUserProfileSetConcurrently(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "kv3") {
+			return
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- UserProfiles.Set(db, "kv3", "counter", fmt.Sprint(i))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+
+		values, err := UserProfiles.All(db, "kv3")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(values) != 1 {
+			t.Error("want 1 value, got", values)
+		}
+	})
+}
+
+func TestUserProfileSetInTx(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "kv4") {
+			return
+		}
+		ctx := context.Background()
+		dialect, _ := DialectByName(*driverName)
+		// defaultDialect 不支持 upsert, 先删除再插入; 其它的 Dialect 使用 upsert
+		for _, store := range []*Store{NewStore(db, defaultDialect{}, nil), NewStore(db, dialect, nil)} {
+			for i := 0; i < 2; i++ {
+				err := InTx(ctx, db, func(tx *sql.Tx) error {
+					return store.Profiles.SetContext(ctx, tx, "kv4", "theme", fmt.Sprint(i))
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+
+		value, version, err := UserProfiles.getWithVersion(ctx, db, "kv4", "theme")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if value != "1" || version != 4 {
+			t.Error("want 1 and version 4, got", value, version)
+		}
+	})
+}
+
+func TestUserProfileFollowsUser(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		user := &User{Name: "owner1"}
+		if _, err := user.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := UserProfiles.Set(db, "owner1", "theme", "dark"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		user.Name = "owner2"
+		if err := user.UpdateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if value, err := UserProfiles.Get(db, "owner2", "theme"); err != nil || value != "dark" {
+			t.Error("want dark, got", value, err)
+		}
+		profiles, err := UserProfiles.QueryWith(db, "WHERE user_id = ?", user.ID)
+		if err != nil || len(profiles) != 1 {
+			t.Error(profiles, err)
+			return
+		}
+		if profiles[0].User != "owner2" {
+			t.Error("want owner2, got", profiles[0].User)
+		}
+
+		// 软删除的用户的属性被保留, 恢复后仍然可以访问
+		if err := user.DeleteIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := UserProfiles.Get(db, "owner2", "theme"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+		if err := Users.Restore(db, user.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if value, err := UserProfiles.Get(db, "owner2", "theme"); err != nil || value != "dark" {
+			t.Error("want dark, got", value, err)
+		}
+
+		if err := Users.PurgeByID(db, user.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		profiles, err = UserProfiles.QueryWith(db, "WHERE user_id = ?", user.ID)
+		if err != nil || len(profiles) != 0 {
+			t.Error("profiles are left", profiles, err)
+		}
+	})
+}
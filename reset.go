@@ -0,0 +1,160 @@
+package permissions
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrResetTokenInvalid - 表示密码重置令牌不存在或已被使用
+	ErrResetTokenInvalid = errors.New("password reset token is invalid")
+
+	// ErrResetTokenExpired - 表示密码重置令牌已过期
+	ErrResetTokenExpired = errors.New("password reset token is expired")
+)
+
+// PasswordResetTTL 密码重置令牌的有效期
+var PasswordResetTTL = time.Hour
+
+// PasswordResetNotifier 负责将密码重置令牌发送给用户, 例如通过邮件或短信
+type PasswordResetNotifier interface {
+	NotifyPasswordReset(user *User, token string, expiresAt time.Time) error
+}
+
+// PasswordResetMessage 是 MemoryResetNotifier 记录下的一条通知
+type PasswordResetMessage struct {
+	User      User
+	Token     string
+	ExpiresAt time.Time
+}
+
+// MemoryResetNotifier 将通知保存在内存中, 用于测试
+type MemoryResetNotifier struct {
+	mu       sync.Mutex
+	messages []PasswordResetMessage
+}
+
+func (n *MemoryResetNotifier) NotifyPasswordReset(user *User, token string, expiresAt time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, PasswordResetMessage{User: *user, Token: token, ExpiresAt: expiresAt})
+	return nil
+}
+
+// Messages 返回已收到的所有通知
+func (n *MemoryResetNotifier) Messages() []PasswordResetMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]PasswordResetMessage(nil), n.messages...)
+}
+
+// Last 返回最后一条通知, 没有通知时返回 nil
+func (n *MemoryResetNotifier) Last() *PasswordResetMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.messages) == 0 {
+		return nil
+	}
+	msg := n.messages[len(n.messages)-1]
+	return &msg
+}
+
+// RequestPasswordResetContext 为用户生成一个一次性的密码重置令牌, 并通过 notifier 发给用户,
+// 数据库中只保存令牌的摘要。
+func (self *users) RequestPasswordResetContext(ctx context.Context, db Executor, userID int64, notifier PasswordResetNotifier) error {
+	user, err := self.FindByIDContext(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(bs)
+
+	now := time.Now()
+	expiresAt := now.Add(PasswordResetTTL)
+	err = self.store.execWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_password_reset_tokens")+"(user_id, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		user.ID, hashToken(token), expiresAt, now)
+	if err != nil {
+		return err
+	}
+	return notifier.NotifyPasswordReset(user, token, expiresAt)
+}
+
+// RequestPasswordReset 使用 context.Background() 调用 RequestPasswordResetContext
+func (self *users) RequestPasswordReset(db Executor, userID int64, notifier PasswordResetNotifier) error {
+	return self.RequestPasswordResetContext(context.Background(), db, userID, notifier)
+}
+
+// ResetPasswordContext 用密码重置令牌设置新密码, 新密码必须满足密码策略。
+// 成功后令牌失效, 该用户其它未使用的令牌也一并失效, 同时清除登录失败的计数。
+func (self *users) ResetPasswordContext(ctx context.Context, db Executor, token, password string) error {
+	queryString, err := self.store.rebind("SELECT id, user_id, expires_at, used_at FROM " + self.store.TableName("tpt_password_reset_tokens") + " WHERE token_hash = ?")
+	if err != nil {
+		return err
+	}
+
+	var id, userID int64
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err = db.QueryRowContext(ctx, queryString, hashToken(token)).Scan(&id, &userID, &expiresAt, &usedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrResetTokenInvalid
+		}
+		return err
+	}
+	if usedAt.Valid {
+		return ErrResetTokenInvalid
+	}
+	now := time.Now()
+	if !now.Before(expiresAt) {
+		return ErrResetTokenExpired
+	}
+
+	// 先检查密码策略, 新密码不满足策略时令牌仍然可以使用
+	hash, err := self.hashNewPassword(ctx, db, userID, password)
+	if err != nil {
+		return err
+	}
+
+	updateString, err := self.store.rebind("UPDATE " + self.store.TableName("tpt_password_reset_tokens") + " SET used_at = ? WHERE id = ? AND used_at IS NULL")
+	if err != nil {
+		return err
+	}
+
+	// 令牌和密码在同一个事务中修改, 以免令牌已经失效密码却没有修改
+	return inTx(ctx, db, func(db Executor) error {
+		result, err := db.ExecContext(ctx, updateString, now, id)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrResetTokenInvalid
+		}
+
+		if err := self.setPasswordHash(ctx, db, userID, hash); err != nil {
+			return err
+		}
+		if err := self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_password_reset_tokens")+" WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+			return err
+		}
+		return self.loginSucceeded(ctx, db, userID)
+	})
+}
+
+// ResetPassword 使用 context.Background() 调用 ResetPasswordContext
+func (self *users) ResetPassword(db Executor, token, password string) error {
+	return self.ResetPasswordContext(context.Background(), db, token, password)
+}
@@ -0,0 +1,94 @@
+package permissions
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestPasswordReset(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		oldPolicy, oldTTL := DefaultPasswordPolicy, PasswordResetTTL
+		defer func() {
+			DefaultPasswordPolicy, PasswordResetTTL = oldPolicy, oldTTL
+		}()
+		DefaultPasswordPolicy = PasswordPolicy{MinLength: 8}
+
+		user1 := &User{
+			Name:     "reset1",
+			Password: "reset1_pwd",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		notifier := &MemoryResetNotifier{}
+		for i := 0; i < 2; i++ {
+			if err := Users.RequestPasswordReset(db, user1.ID, notifier); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		messages := notifier.Messages()
+		if len(messages) != 2 {
+			t.Error(len(messages))
+			return
+		}
+		if messages[0].User.ID != user1.ID || messages[0].Token == "" || messages[0].Token == messages[1].Token {
+			t.Error(messages)
+		}
+
+		if err := Users.ResetPassword(db, messages[1].Token, "short"); err == nil {
+			t.Error("password policy isn't checked")
+		} else if _, ok := err.(*PasswordPolicyError); !ok {
+			t.Error("want PasswordPolicyError, got", err)
+		}
+
+		if err := Users.ResetPassword(db, messages[1].Token, "reset1_new"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "reset1", "reset1_new"); err != nil {
+			t.Error(err)
+		}
+
+		// 令牌只能使用一次, 其它未使用的令牌也失效了
+		for _, msg := range messages {
+			if err := Users.ResetPassword(db, msg.Token, "reset1_new2"); err != ErrResetTokenInvalid {
+				t.Error("want ErrResetTokenInvalid, got", err)
+			}
+		}
+
+		// 修改密码失败时令牌仍然可以使用
+		if err := Users.RequestPasswordReset(db, user1.ID, notifier); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := db.Exec("DROP TABLE tpt_user_password_history"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.ResetPassword(db, notifier.Last().Token, "reset1_new2"); err == nil {
+			t.Error("want an error, got nil")
+		}
+		var unused int64
+		if err := db.QueryRow("SELECT count(*) FROM tpt_password_reset_tokens WHERE used_at IS NULL").Scan(&unused); err != nil {
+			t.Error(err)
+		} else if unused != 1 {
+			t.Error("want 1 unused token, got", unused)
+		}
+		if _, err := Authenticate(db, "reset1", "reset1_new"); err != nil {
+			t.Error(err)
+		}
+
+		PasswordResetTTL = -time.Second
+		if err := Users.RequestPasswordReset(db, user1.ID, notifier); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.ResetPassword(db, notifier.Last().Token, "reset1_new2"); err != ErrResetTokenExpired {
+			t.Error("want ErrResetTokenExpired, got", err)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package permissions
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSessionInvalid - 表示会话令牌格式不正确或签名不正确
+	ErrSessionInvalid = errors.New("session token is invalid")
+
+	// ErrSessionExpired - 表示会话令牌已过期
+	ErrSessionExpired = errors.New("session token is expired")
+
+	// ErrSessionAudience - 表示会话令牌不是签发给当前服务的
+	ErrSessionAudience = errors.New("session token audience is mismatch")
+)
+
+// SessionKey 代表一个签名密钥, ID 会写入令牌头部的 kid 中用于轮换密钥
+type SessionKey struct {
+	ID     string
+	Secret []byte
+}
+
+// SessionSigner 签发和校验与 JWT(HS256) 兼容的会话令牌, 令牌中带有 UserRBAC 的快照,
+// 微服务之间可以不访问数据库就校验用户的权限。
+//
+// 轮换密钥时将新密钥放在 Keys 的第一个, 它用于签发新令牌, 旧密钥保留在后面,
+// 直到用它签发的令牌全部过期后再删除。
+type SessionSigner struct {
+	// Issuer 签发者, 不为空时写入令牌并在校验时检查
+	Issuer string
+	// TTL 令牌的有效期, 必须大于 0, 除非 NoExpiry 为 true
+	TTL time.Duration
+	// NoExpiry 为 true 时签发的令牌永不过期(此时忽略 TTL), 校验时也接受没有 exp 的令牌,
+	// 否则没有 exp 的令牌被视为无效
+	NoExpiry bool
+	// Leeway 校验过期时间时允许的时钟偏差
+	Leeway time.Duration
+	// Keys 第一个密钥用于签名, 所有的密钥都可用于校验
+	Keys []SessionKey
+}
+
+type sessionHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+type sessionClaims struct {
+	Issuer            string          `json:"iss,omitempty"`
+	Subject           string          `json:"sub"`
+	Audience          sessionAudience `json:"aud,omitempty"`
+	IssuedAt          int64           `json:"iat,omitempty"`
+	ExpiresAt         int64           `json:"exp,omitempty"`
+	Name              string          `json:"name"`
+	Roles             []string        `json:"roles,omitempty"`
+	Permissions       []string        `json:"perms,omitempty"`
+	Scopes            []string        `json:"scp,omitempty"`
+	PermissionVersion string          `json:"pv,omitempty"`
+}
+
+// sessionAudience 对应 JWT 中的 aud, 它可以是一个字符串, 也可以是字符串数组
+type sessionAudience []string
+
+func (aud sessionAudience) MarshalJSON() ([]byte, error) {
+	if len(aud) == 1 {
+		return json.Marshal(aud[0])
+	}
+	return json.Marshal([]string(aud))
+}
+
+func (aud *sessionAudience) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte("[")) {
+		return json.Unmarshal(data, (*[]string)(aud))
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*aud = sessionAudience{s}
+	return nil
+}
+
+// PermissionVersion 返回用户权限的版本, 它是角色和权限的摘要,
+// 角色或权限变化后版本也随之变化, 可用于判断会话令牌中的快照是否已经过时。
+func (self *UserRBAC) PermissionVersion() string {
+	roles := append([]string(nil), self.Roles...)
+	sort.Strings(roles)
+
+	h := sha256.New()
+	h.Write([]byte(strings.Join(roles, ",")))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(self.PermissionKeys(), ",")))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// checkKeys 检查密钥, 空的密钥签出的令牌任何人都可以伪造
+func (s *SessionSigner) checkKeys() error {
+	if len(s.Keys) == 0 {
+		return errors.New("session key is missing")
+	}
+	for _, key := range s.Keys {
+		if len(key.Secret) == 0 {
+			return errors.New("secret of session key '" + key.ID + "' is empty")
+		}
+	}
+	return nil
+}
+
+// Issue 为 rbac 签发一个会话令牌, audience 为接收令牌的服务
+func (s *SessionSigner) Issue(rbac *UserRBAC, audience ...string) (string, error) {
+	if err := s.checkKeys(); err != nil {
+		return "", err
+	}
+	if !s.NoExpiry && s.TTL <= 0 {
+		return "", errors.New("session ttl must be positive")
+	}
+	key := s.Keys[0]
+
+	now := time.Now()
+	claims := sessionClaims{
+		Issuer:            s.Issuer,
+		Subject:           strconv.FormatInt(rbac.User.ID, 10),
+		Audience:          audience,
+		IssuedAt:          now.Unix(),
+		Name:              rbac.User.Name,
+		Roles:             rbac.Roles,
+		Permissions:       rbac.PermissionKeys(),
+		PermissionVersion: rbac.PermissionVersion(),
+	}
+	if !s.NoExpiry {
+		claims.ExpiresAt = now.Add(s.TTL).Unix()
+	}
+	if rbac.scopes != nil {
+		claims.Scopes = make([]string, 0, len(rbac.scopes))
+		for scope := range rbac.scopes {
+			claims.Scopes = append(claims.Scopes, scope)
+		}
+		sort.Strings(claims.Scopes)
+	}
+
+	header, err := json.Marshal(sessionHeader{Alg: "HS256", Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signSession(key.Secret, signingInput)), nil
+}
+
+func signSession(secret []byte, signingInput string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signingInput))
+	return h.Sum(nil)
+}
+
+// Verify 校验会话令牌并还原出 UserRBAC, 还原出的 User 中只有 ID 和 Name。
+// audience 不为空时令牌的 aud 中必须包含它, 为空时令牌中不能有 aud,
+// 以免签发给其它服务的令牌被不检查 audience 的服务接受。
+func (s *SessionSigner) Verify(token, audience string) (*UserRBAC, error) {
+	if err := s.checkKeys(); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrSessionInvalid
+	}
+
+	var header sessionHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrSessionInvalid
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrSessionInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	verified := false
+	for _, key := range s.Keys {
+		if header.Kid != "" && header.Kid != key.ID {
+			continue
+		}
+		if hmac.Equal(signature, signSession(key.Secret, signingInput)) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSessionInvalid
+	}
+
+	var claims sessionClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrSessionInvalid
+	}
+	if s.Issuer != "" && claims.Issuer != s.Issuer {
+		return nil, ErrSessionInvalid
+	}
+	if claims.ExpiresAt == 0 {
+		if !s.NoExpiry {
+			return nil, ErrSessionInvalid
+		}
+	} else if time.Now().Add(-s.Leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrSessionExpired
+	}
+	if audience == "" {
+		if len(claims.Audience) > 0 {
+			return nil, ErrSessionAudience
+		}
+	} else {
+		found := false
+		for _, aud := range claims.Audience {
+			if aud == audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrSessionAudience
+		}
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	rbac := &UserRBAC{
+		User:        User{ID: id, Name: claims.Name},
+		Roles:       claims.Roles,
+		permissions: map[string]struct{}{},
+	}
+	for _, key := range claims.Permissions {
+		rbac.permissions[key] = struct{}{}
+	}
+	if claims.Scopes != nil {
+		rbac.scopes = map[string]struct{}{}
+		for _, scope := range claims.Scopes {
+			rbac.scopes[scope] = struct{}{}
+		}
+	}
+	return rbac, nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
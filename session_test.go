@@ -0,0 +1,152 @@
+package permissions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionSigner(t *testing.T) {
+	rbac := &UserRBAC{
+		User:        User{ID: 12, Name: "session1"},
+		Roles:       []string{"r1", "r2"},
+		permissions: map[string]struct{}{"k1": {}, "k2": {}},
+	}
+
+	oldKey := SessionKey{ID: "2019", Secret: []byte("old secret")}
+	newKey := SessionKey{ID: "2020", Secret: []byte("new secret")}
+	signer := &SessionSigner{
+		Issuer: "tpt",
+		TTL:    time.Hour,
+		Keys:   []SessionKey{oldKey},
+	}
+
+	token1, err := signer.Issue(rbac, "svc1", "svc2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 轮换密钥后旧令牌仍然有效
+	signer.Keys = []SessionKey{newKey, oldKey}
+	token2, err := signer.Issue(rbac, "svc1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, token := range []string{token1, token2} {
+		session, err := signer.Verify(token, "svc1")
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if session.User.ID != 12 || session.User.Name != "session1" {
+			t.Error(session.User)
+		}
+		if strings.Join(session.Roles, ",") != "r1,r2" {
+			t.Error(session.Roles)
+		}
+		if !session.HasPermission("k1") || !session.HasPermission("k2") || session.HasPermission("k3") {
+			t.Error(session.PermissionKeys())
+		}
+		if session.PermissionVersion() != rbac.PermissionVersion() {
+			t.Error(session.PermissionVersion(), rbac.PermissionVersion())
+		}
+	}
+
+	if _, err := signer.Verify(token2, "svc2"); err != ErrSessionAudience {
+		t.Error("want ErrSessionAudience, got", err)
+	}
+	if _, err := signer.Verify(token2, ""); err != ErrSessionAudience {
+		t.Error("want ErrSessionAudience, got", err)
+	}
+
+	// 删除旧密钥后用它签发的令牌失效
+	signer.Keys = []SessionKey{newKey}
+	if _, err := signer.Verify(token1, "svc1"); err != ErrSessionInvalid {
+		t.Error("want ErrSessionInvalid, got", err)
+	}
+	if _, err := signer.Verify(token2[:len(token2)-2], "svc1"); err != ErrSessionInvalid {
+		t.Error("want ErrSessionInvalid, got", err)
+	}
+
+	token3, err := signer.Issue(rbac)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// 负的 Leeway 相当于校验方的时钟快了, 快过 TTL 时令牌过期
+	signer.Leeway = -61 * time.Minute
+	if _, err := signer.Verify(token3, ""); err != ErrSessionExpired {
+		t.Error("want ErrSessionExpired, got", err)
+	}
+	signer.Leeway = -59 * time.Minute
+	if _, err := signer.Verify(token3, ""); err != nil {
+		t.Error(err)
+	}
+	signer.Leeway = 0
+
+	rbac.scopes = map[string]struct{}{"k1": {}}
+	token4, err := signer.Issue(rbac)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	session, err := signer.Verify(token4, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !session.HasPermission("k1") || session.HasPermission("k2") {
+		t.Error(session.PermissionKeys())
+	}
+
+	// 永不过期的令牌需要明确打开 NoExpiry
+	signer.TTL = 0
+	if _, err := signer.Issue(rbac); err == nil {
+		t.Error("issue a token without ttl")
+	}
+	signer.NoExpiry = true
+	token5, err := signer.Issue(rbac)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := signer.Verify(token5, ""); err != nil {
+		t.Error(err)
+	}
+	signer.NoExpiry = false
+	signer.TTL = time.Hour
+	if _, err := signer.Verify(token5, ""); err != ErrSessionInvalid {
+		t.Error("want ErrSessionInvalid, got", err)
+	}
+
+	// 空的密钥既不能签发也不能校验
+	empty := &SessionSigner{TTL: time.Hour, Keys: []SessionKey{{ID: "empty"}}}
+	if _, err := empty.Issue(rbac); err == nil {
+		t.Error("issue a token with an empty secret")
+	}
+	if _, err := empty.Verify(token4, ""); err == nil {
+		t.Error("verify a token with an empty secret")
+	}
+}
+
+func TestSessionSignerJWTCompatible(t *testing.T) {
+	// jwt.io 上的示例令牌
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+		"eyJzdWIiOiIxMjM0NTY3ODkwIiwibmFtZSI6IkpvaG4gRG9lIiwiaWF0IjoxNTE2MjM5MDIyfQ." +
+		"SflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c"
+	signer := &SessionSigner{
+		NoExpiry: true,
+		Keys:     []SessionKey{{Secret: []byte("your-256-bit-secret")}},
+	}
+	session, err := signer.Verify(token, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if session.User.ID != 1234567890 || session.User.Name != "John Doe" {
+		t.Error(session.User)
+	}
+}
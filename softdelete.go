@@ -0,0 +1,193 @@
+package permissions
+
+import (
+	"context"
+	"time"
+)
+
+// ReuseDeletedNames 为 true 时已删除的用户和角色的名称可以被新的记录使用,
+// 此时如果名称已被占用, 恢复(Restore)它会返回 DuplicateError。
+var ReuseDeletedNames bool
+
+// WithDeleted 返回一个包含已删除的用户的查询对象, 例如
+//
+//	Users.WithDeleted().FindByName(db, name)
+func (self *users) WithDeleted() *users {
+	return &users{store: self.store, withDeleted: true}
+}
+
+// RestoreContext 恢复一个已删除的用户
+func (self *users) RestoreContext(ctx context.Context, db Executor, key int64) error {
+	return self.store.restore(ctx, db, "tpt_users", key)
+}
+
+// Restore 使用 context.Background() 调用 RestoreContext
+func (self *users) Restore(db Executor, key int64) error {
+	return self.RestoreContext(context.Background(), db, key)
+}
+
+// PurgeByIDContext 从数据库中彻底删除一个用户和他的属性, 不论它是否已被软删除。
+// 软删除的用户的属性会被保留, 以便恢复用户时一同恢复。
+func (self *users) PurgeByIDContext(ctx context.Context, db Executor, key int64) error {
+	// 外键上有 ON DELETE CASCADE, 这里再删除一次是为了没有启用外键约束的数据库(如 sqlite)
+	if err := self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_profiles")+" WHERE user_id = ?", key); err != nil {
+		return err
+	}
+	return self.store.purge(ctx, db, "tpt_users", key)
+}
+
+// PurgeByID 使用 context.Background() 调用 PurgeByIDContext
+func (self *users) PurgeByID(db Executor, key int64) error {
+	return self.PurgeByIDContext(context.Background(), db, key)
+}
+
+// PurgeDeletedContext 彻底删除在 before 之前被软删除的用户和他们的属性, 返回删除的用户的个数
+func (self *users) PurgeDeletedContext(ctx context.Context, db Executor, before time.Time) (int64, error) {
+	err := self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_profiles")+" WHERE user_id IN"+
+		" (SELECT id FROM "+self.store.TableName("tpt_users")+" WHERE deleted_at IS NOT NULL AND deleted_at < ?)", before)
+	if err != nil {
+		return 0, err
+	}
+	return self.store.purgeDeleted(ctx, db, "tpt_users", before)
+}
+
+// PurgeDeleted 使用 context.Background() 调用 PurgeDeletedContext
+func (self *users) PurgeDeleted(db Executor, before time.Time) (int64, error) {
+	return self.PurgeDeletedContext(context.Background(), db, before)
+}
+
+// WithDeleted 返回一个包含已删除的角色的查询对象
+func (self *roles) WithDeleted() *roles {
+	return &roles{store: self.store, withDeleted: true}
+}
+
+// RestoreContext 恢复一个已删除的角色
+func (self *roles) RestoreContext(ctx context.Context, db Executor, key int64) error {
+	return self.store.restore(ctx, db, "tpt_roles", key)
+}
+
+// Restore 使用 context.Background() 调用 RestoreContext
+func (self *roles) Restore(db Executor, key int64) error {
+	return self.RestoreContext(context.Background(), db, key)
+}
+
+// PurgeByIDContext 从数据库中彻底删除一个角色, 不论它是否已被软删除
+func (self *roles) PurgeByIDContext(ctx context.Context, db Executor, key int64) error {
+	return self.store.purge(ctx, db, "tpt_roles", key)
+}
+
+// PurgeByID 使用 context.Background() 调用 PurgeByIDContext
+func (self *roles) PurgeByID(db Executor, key int64) error {
+	return self.PurgeByIDContext(context.Background(), db, key)
+}
+
+// PurgeDeletedContext 彻底删除在 before 之前被软删除的角色, 返回删除的个数
+func (self *roles) PurgeDeletedContext(ctx context.Context, db Executor, before time.Time) (int64, error) {
+	return self.store.purgeDeleted(ctx, db, "tpt_roles", before)
+}
+
+// PurgeDeleted 使用 context.Background() 调用 PurgeDeletedContext
+func (self *roles) PurgeDeleted(db Executor, before time.Time) (int64, error) {
+	return self.PurgeDeletedContext(context.Background(), db, before)
+}
+
+func (s *Store) softDelete(ctx context.Context, db Executor, table string, key int64) error {
+	if 0 == key {
+		return ThrowPrimaryKeyInvalid(table)
+	}
+
+	updateString, err := s.rebind("UPDATE " + s.TableName(table) + " SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, updateString, time.Now(), key)
+	if nil != err {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if nil != err {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotDeleted
+	}
+	return nil
+}
+
+func (s *Store) restore(ctx context.Context, db Executor, table string, key int64) error {
+	if 0 == key {
+		return ThrowPrimaryKeyInvalid(table)
+	}
+
+	updateString, err := s.rebind("UPDATE " + s.TableName(table) + " SET deleted_at = NULL, updated_at = ? WHERE id = ? AND deleted_at IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, updateString, time.Now(), key)
+	if nil != err {
+		return toDuplicateError(table, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if nil != err {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotUpdated
+	}
+	return nil
+}
+
+func (s *Store) purge(ctx context.Context, db Executor, table string, key int64) error {
+	if 0 == key {
+		return ThrowPrimaryKeyInvalid(table)
+	}
+
+	deleteString, err := s.rebind("DELETE FROM " + s.TableName(table) + " WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, deleteString, key)
+	if nil != err {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if nil != err {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotDeleted
+	}
+	return nil
+}
+
+func (s *Store) purgeDeleted(ctx context.Context, db Executor, table string, before time.Time) (int64, error) {
+	deleteString, err := s.rebind("DELETE FROM " + s.TableName(table) + " WHERE deleted_at IS NOT NULL AND deleted_at < ?")
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.ExecContext(ctx, deleteString, before)
+	if nil != err {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// checkDeletedName 在 ReuseDeletedNames 为 false 时检查名称是否被已删除的记录占用
+func (s *Store) checkDeletedName(ctx context.Context, db Executor, table, name string, id int64) error {
+	if s.reuseDeletedNames() {
+		return nil
+	}
+
+	queryString, err := s.rebind("SELECT count(*) FROM " + s.TableName(table) + " WHERE name = ? AND id <> ? AND deleted_at IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err := db.QueryRowContext(ctx, queryString, name, id).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return &DuplicateError{Table: table, Field: "name", Value: name}
+	}
+	return nil
+}
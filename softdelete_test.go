@@ -0,0 +1,142 @@
+package permissions
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		role1 := &Role{Name: "sd_role1", PermissionKeys: "a,b"}
+		if _, err := role1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		user1 := &User{Name: "sd1", Password: "sd1_pwd"}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.AddRole(db, user1.ID, role1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := user1.DeleteIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := user1.DeleteIt(db); err != ErrNotDeleted {
+			t.Error("want ErrNotDeleted, got", err)
+		}
+
+		if _, err := Users.FindByName(db, "sd1"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+		if _, err := Authenticate(db, "sd1", "sd1_pwd"); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+		deleted, err := Users.WithDeleted().FindByName(db, "sd1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if deleted.DeletedAt.IsZero() {
+			t.Error("deleted_at is empty")
+		}
+
+		// 默认不能重用已删除的名称
+		if _, err := (&User{Name: "sd1"}).CreateIt(db); err == nil {
+			t.Error("reuse a deleted name")
+		} else if e, ok := err.(*DuplicateError); !ok || e.Field != "name" {
+			t.Error("want DuplicateError, got", err)
+		}
+
+		if err := Users.Restore(db, user1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.Restore(db, user1.ID); err != ErrNotUpdated {
+			t.Error("want ErrNotUpdated, got", err)
+		}
+		rbac, err := QueryUserRBAC(db, "sd1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !rbac.HasPermission("a") {
+			t.Error("roles of restored user is lost")
+		}
+
+		// 删除角色后用户不再拥有它的权限
+		if err := role1.DeleteIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		rbac, err = QueryUserRBAC(db, "sd1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if rbac.HasPermission("a") {
+			t.Error("permission of deleted role is present")
+		}
+		if err := Roles.Restore(db, role1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := Users.PurgeByID(db, user1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Users.WithDeleted().FindByID(db, user1.ID); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+	})
+}
+
+func TestSoftDeleteReuseName(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		old := ReuseDeletedNames
+		ReuseDeletedNames = true
+		defer func() {
+			ReuseDeletedNames = old
+		}()
+
+		user1 := &User{Name: "sd2"}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := user1.DeleteIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		user2 := &User{Name: "sd2"}
+		if _, err := user2.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := Users.Restore(db, user1.ID); err == nil {
+			t.Error("restore a user whose name is taken")
+		} else if _, ok := err.(*DuplicateError); !ok {
+			t.Error("want DuplicateError, got", err)
+		}
+
+		count, err := Users.PurgeDeleted(db, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if count != 1 {
+			t.Error("want 1, got", count)
+		}
+		if _, err := Users.FindByName(db, "sd2"); err != nil {
+			t.Error(err)
+		}
+	})
+}
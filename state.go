@@ -0,0 +1,238 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// userStateTransitions 列出了每个状态允许转换到的状态
+var userStateTransitions = map[int64][]int64{
+	UserPending:  {UserActive, UserDisabled},
+	UserActive:   {UserDisabled, UserLocked, UserExpired},
+	UserDisabled: {UserActive},
+	UserLocked:   {UserActive, UserDisabled},
+	UserExpired:  {UserActive, UserDisabled},
+}
+
+// UserStateString 返回用户状态的名称
+func UserStateString(state int64) string {
+	switch state {
+	case UserActive:
+		return "active"
+	case UserDisabled:
+		return "disabled"
+	case UserPending:
+		return "pending"
+	case UserLocked:
+		return "locked"
+	case UserExpired:
+		return "expired"
+	default:
+		return "unknown(" + strconv.FormatInt(state, 10) + ")"
+	}
+}
+
+// CanTransitUserState 判断用户状态是否可以从 from 转换到 to
+func CanTransitUserState(from, to int64) bool {
+	for _, s := range userStateTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateTransitionError 表示不允许的用户状态转换
+type StateTransitionError struct {
+	From int64
+	To   int64
+}
+
+func (e *StateTransitionError) Error() string {
+	return "can't change user state from '" + UserStateString(e.From) + "' to '" + UserStateString(e.To) + "'"
+}
+
+// userStateError 返回不能登录的用户状态对应的错误
+func userStateError(state int64) error {
+	switch state {
+	case UserActive:
+		return nil
+	case UserDisabled:
+		return ErrUserDisabled
+	case UserLocked:
+		return ErrUserLocked
+	default:
+		return ErrUserNotActive
+	}
+}
+
+// UserStateChange 代表一次用户状态变更的记录
+type UserStateChange struct {
+	ID        int64     `json:"id,omitempty"`
+	UserID    int64     `json:"user_id,omitempty"`
+	From      int64     `json:"from_state"`
+	To        int64     `json:"to_state"`
+	Operator  string    `json:"operator,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// ActivateContext 激活用户
+func (self *users) ActivateContext(ctx context.Context, db Executor, userID int64, operator, reason string) error {
+	return self.ChangeStateContext(ctx, db, userID, UserActive, operator, reason)
+}
+
+// Activate 使用 context.Background() 调用 ActivateContext
+func (self *users) Activate(db Executor, userID int64, operator, reason string) error {
+	return self.ActivateContext(context.Background(), db, userID, operator, reason)
+}
+
+// DisableContext 禁用用户
+func (self *users) DisableContext(ctx context.Context, db Executor, userID int64, operator, reason string) error {
+	return self.ChangeStateContext(ctx, db, userID, UserDisabled, operator, reason)
+}
+
+// Disable 使用 context.Background() 调用 DisableContext
+func (self *users) Disable(db Executor, userID int64, operator, reason string) error {
+	return self.DisableContext(context.Background(), db, userID, operator, reason)
+}
+
+// LockContext 锁定用户
+func (self *users) LockContext(ctx context.Context, db Executor, userID int64, operator, reason string) error {
+	return self.ChangeStateContext(ctx, db, userID, UserLocked, operator, reason)
+}
+
+// Lock 使用 context.Background() 调用 LockContext
+func (self *users) Lock(db Executor, userID int64, operator, reason string) error {
+	return self.LockContext(context.Background(), db, userID, operator, reason)
+}
+
+// UnlockContext 解锁用户, 同时清除登录失败的计数和登录失败导致的锁定。
+// 用户的状态不是 UserLocked 时只清除登录失败的计数和锁定, 不变更状态。
+func (self *users) UnlockContext(ctx context.Context, db Executor, userID int64, operator, reason string) error {
+	user, err := self.FindByIDContext(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	return inTx(ctx, db, func(db Executor) error {
+		if user.State == UserLocked {
+			if err := self.changeState(ctx, db, user, UserActive, operator, reason); err != nil {
+				return err
+			}
+		}
+		return self.loginSucceeded(ctx, db, userID)
+	})
+}
+
+// Unlock 使用 context.Background() 调用 UnlockContext
+func (self *users) Unlock(db Executor, userID int64, operator, reason string) error {
+	return self.UnlockContext(context.Background(), db, userID, operator, reason)
+}
+
+// ChangeStateContext 按 userStateTransitions 中的规则变更用户状态, 并记录操作人和原因
+func (self *users) ChangeStateContext(ctx context.Context, db Executor, userID, to int64, operator, reason string) error {
+	user, err := self.FindByIDContext(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	return self.changeState(ctx, db, user, to, operator, reason)
+}
+
+// ChangeState 使用 context.Background() 调用 ChangeStateContext
+func (self *users) ChangeState(db Executor, userID, to int64, operator, reason string) error {
+	return self.ChangeStateContext(context.Background(), db, userID, to, operator, reason)
+}
+
+func (self *users) changeState(ctx context.Context, db Executor, user *User, to int64, operator, reason string) error {
+	if !CanTransitUserState(user.State, to) {
+		return &StateTransitionError{From: user.State, To: to}
+	}
+
+	// 带上原状态作为条件, 防止并发修改时跳过转换规则
+	updateString := "UPDATE " + self.store.TableName("tpt_users") + " SET state = ?, updated_at = ? WHERE id = ? AND state = ?"
+	updateString, err := self.store.rebind(updateString)
+	if err != nil {
+		return err
+	}
+	insertString := "INSERT INTO " + self.store.TableName("tpt_user_state_changes") + "(user_id, from_state, to_state, operator, reason, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+	insertString, err = self.store.rebind(insertString)
+	if err != nil {
+		return err
+	}
+
+	// 状态和变更记录在同一个事务中修改, 以免状态变了却没有记录
+	now := time.Now()
+	err = inTx(ctx, db, func(db Executor) error {
+		result, err := db.ExecContext(ctx, updateString, to, now, user.ID, user.State)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrNotUpdated
+		}
+
+		_, err = db.ExecContext(ctx, insertString, user.ID, user.State, to, operator, reason, now)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	user.State = to
+	user.UpdatedAt = now
+	return nil
+}
+
+// ListStateChangesContext 列出用户的状态变更记录, 按时间先后排序
+func (self *users) ListStateChangesContext(ctx context.Context, db Executor, userID int64) ([]*UserStateChange, error) {
+	queryString := "SELECT id, user_id, from_state, to_state, operator, reason, created_at FROM " + self.store.TableName("tpt_user_state_changes") + " WHERE user_id = ? ORDER BY id"
+	queryString, err := self.store.rebind(queryString)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, queryString, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*UserStateChange, 0, 4)
+	for rows.Next() {
+		var value UserStateChange
+		var nullOperator sql.NullString
+		var nullReason sql.NullString
+		var nullCreatedAt sql.NullTime
+		if err := rows.Scan(&value.ID,
+			&value.UserID,
+			&value.From,
+			&value.To,
+			&nullOperator,
+			&nullReason,
+			&nullCreatedAt); err != nil {
+			return nil, err
+		}
+		if nullOperator.Valid {
+			value.Operator = nullOperator.String
+		}
+		if nullReason.Valid {
+			value.Reason = nullReason.String
+		}
+		if nullCreatedAt.Valid {
+			value.CreatedAt = nullCreatedAt.Time
+		}
+		results = append(results, &value)
+	}
+	return results, rows.Err()
+}
+
+// ListStateChanges 使用 context.Background() 调用 ListStateChangesContext
+func (self *users) ListStateChanges(db Executor, userID int64) ([]*UserStateChange, error) {
+	return self.ListStateChangesContext(context.Background(), db, userID)
+}
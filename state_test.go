@@ -0,0 +1,132 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestUserState(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		user1 := &User{
+			Name:     "state1",
+			Password: "state1_pwd",
+			State:    UserPending,
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != ErrUserNotActive {
+			t.Error("want ErrUserNotActive, got", err)
+		}
+		if _, err := QueryUserRBAC(db, "state1"); err != ErrUserNotActive {
+			t.Error("want ErrUserNotActive, got", err)
+		}
+
+		err := Users.Lock(db, user1.ID, "admin", "lock a pending user")
+		if _, ok := err.(*StateTransitionError); !ok {
+			t.Error("want StateTransitionError, got", err)
+		}
+
+		if err := Users.Activate(db, user1.ID, "admin", "approved"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != nil {
+			t.Error(err)
+		}
+
+		if err := Users.Lock(db, user1.ID, "admin", "suspicious"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != ErrUserLocked {
+			t.Error("want ErrUserLocked, got", err)
+		}
+
+		if err := Users.Unlock(db, user1.ID, "admin", "checked"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 登录失败导致的锁定不改变状态, 也可以用 Unlock 解除
+		for i := int64(0); i < MaxLoginFailures; i++ {
+			if _, err := Authenticate(db, "state1", "bad"); err != ErrPasswordIncorrect {
+				t.Error("want ErrPasswordIncorrect, got", err)
+			}
+		}
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != ErrUserLocked {
+			t.Error("want ErrUserLocked, got", err)
+		}
+		if err := Users.Unlock(db, user1.ID, "admin", "again"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != nil {
+			t.Error(err)
+		}
+
+		if err := Users.Disable(db, user1.ID, "admin", "left"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "state1", "state1_pwd"); err != ErrUserDisabled {
+			t.Error("want ErrUserDisabled, got", err)
+		}
+
+		changes, err := Users.ListStateChanges(db, user1.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := []UserStateChange{
+			{From: UserPending, To: UserActive, Operator: "admin", Reason: "approved"},
+			{From: UserActive, To: UserLocked, Operator: "admin", Reason: "suspicious"},
+			{From: UserLocked, To: UserActive, Operator: "admin", Reason: "checked"},
+			{From: UserActive, To: UserDisabled, Operator: "admin", Reason: "left"},
+		}
+		if len(changes) != len(expected) {
+			t.Error("len(changes) != len(expected)", len(changes), len(expected))
+			return
+		}
+		for idx, change := range changes {
+			if change.UserID != user1.ID ||
+				change.From != expected[idx].From ||
+				change.To != expected[idx].To ||
+				change.Operator != expected[idx].Operator ||
+				change.Reason != expected[idx].Reason {
+				t.Error(idx, change, expected[idx])
+			}
+			if change.CreatedAt.IsZero() {
+				t.Error(idx, "change.CreatedAt.IsZero()")
+			}
+		}
+
+		// 变更记录写入失败时状态也不会改变
+		if _, err := db.Exec("DROP TABLE tpt_user_state_changes"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.Activate(db, user1.ID, "admin", "back"); err == nil {
+			t.Error("want an error, got nil")
+		}
+		// 在 *sql.Conn 上同样使用事务
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.ActivateContext(context.Background(), conn, user1.ID, "admin", "back"); err == nil {
+			t.Error("want an error, got nil")
+		}
+		conn.Close()
+		user, err := Users.FindByID(db, user1.ID)
+		if err != nil {
+			t.Error(err)
+		} else if user.State != UserDisabled {
+			t.Error("want disabled, got", UserStateString(user.State))
+		}
+	})
+}
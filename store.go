@@ -0,0 +1,156 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// StoreOptions 是 Store 的选项, ReuseDeletedNames, UniqueEmail 和 UniquePhone 的含义与同名的包级变量相同
+type StoreOptions struct {
+	ReuseDeletedNames bool
+	UniqueEmail       bool
+	UniquePhone       bool
+
+	// TablePrefix 替换表名中默认的 tpt_ 前缀, 例如为 app_ 时 tpt_users 变为 app_users,
+	// 迁移中创建的索引和约束的名称也使用这个前缀。
+	//
+	// 表名只用于 Store 自己生成的 SQL, 传给 QueryWith 等方法的条件不会被改写, 其中的
+	// tpt_users.xxx 引用的是查询中固定的别名, 引用其它表时请使用 Store.TableName
+	TablePrefix string
+	// Schema 表所在的 schema, 为空时使用连接默认的 schema
+	Schema string
+	// Tables 单独指定某些表的名称, 键为默认的表名, 例如 {"tpt_users": "accounts"}, 优先于 TablePrefix
+	Tables map[string]string
+}
+
+// defaultTables 是所有的表的默认名称, 见 StoreOptions.TablePrefix
+var defaultTables = map[string]bool{
+	"tpt_roles":                 true,
+	"tpt_users":                 true,
+	"tpt_user_roles":            true,
+	"tpt_user_profiles":         true,
+	"tpt_user_state_changes":    true,
+	"tpt_user_password_history": true,
+	"tpt_user_totp":             true,
+	"tpt_user_recovery_codes":   true,
+	"tpt_user_tokens":           true,
+	"tpt_password_reset_tokens": true,
+	"tpt_schema_migrations":     true,
+	"tpt_schema_lock":           true,
+}
+
+// Store 持有数据库连接和方言设置, 并提供 Users, Roles 和 Profiles 三个数据访问对象。
+// Store 创建后不再修改, 可以被多个 goroutine 同时使用, 同一个进程中可以有多个
+// 连接不同数据库的 Store。
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	options *StoreOptions
+
+	Users    *users
+	Roles    *roles
+	Profiles *userProfiles
+}
+
+// NewStore 创建一个 Store, dialect 为 nil 时使用 Postgres,
+// options 为 nil 时使用 ReuseDeletedNames, UniqueEmail 和 UniquePhone 这几个包级变量。
+func NewStore(db *sql.DB, dialect Dialect, options *StoreOptions) *Store {
+	if dialect == nil {
+		dialect = Postgres
+	}
+	if options != nil {
+		copied := *options
+		copied.Tables = make(map[string]string, len(options.Tables))
+		for name, table := range options.Tables {
+			copied.Tables[name] = table
+		}
+		options = &copied
+	}
+
+	s := &Store{db: db, dialect: dialect, options: options}
+	s.Users = &users{store: s}
+	s.Roles = &roles{store: s}
+	s.Profiles = &userProfiles{store: s}
+	return s
+}
+
+// DefaultStore 是包级的 Users, Roles 和 UserProfiles 使用的 Store,
+// 它的方言由 PlaceholderFormat 和 IsReturning 决定。
+var DefaultStore = NewStore(nil, defaultDialect{}, nil)
+
+// DB 返回创建 Store 时传入的数据库连接
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Dialect 返回 Store 使用的方言
+func (s *Store) Dialect() Dialect {
+	return s.dialect
+}
+
+// InTx 在 Store 的数据库连接上执行事务, 见 InTx
+func (s *Store) InTx(ctx context.Context, fn func(tx *sql.Tx) error, opts ...TxOption) error {
+	return InTx(ctx, s.db, fn, opts...)
+}
+
+func (s *Store) rebind(sql string) (string, error) {
+	return s.dialect.Placeholder(sql)
+}
+
+// TableName 返回表在数据库中的实际名称(包括 schema), name 为默认的表名,
+// 例如 TableName("tpt_users"), 用于在自己的查询或报表中引用这些表
+func (s *Store) TableName(name string) string {
+	table := s.bareTableName(name)
+	if s.options != nil && s.options.Schema != "" {
+		return s.options.Schema + "." + table
+	}
+	return table
+}
+
+// bareTableName 返回不包括 schema 的表名
+func (s *Store) bareTableName(name string) string {
+	if s.options == nil {
+		return name
+	}
+	if table, ok := s.options.Tables[name]; ok {
+		return table
+	}
+	if s.options.TablePrefix != "" {
+		return s.options.TablePrefix + strings.TrimPrefix(name, "tpt_")
+	}
+	return name
+}
+
+// objectName 返回迁移脚本中的名称在数据库中的实际名称, 默认的表名返回不包括 schema 的表名,
+// 用于 tpt_users.id 这样的限定列名, 索引和约束等其它对象的名称只替换 tpt_ 前缀
+func (s *Store) objectName(name string) string {
+	if defaultTables[name] {
+		return s.bareTableName(name)
+	}
+	if s.options != nil && s.options.TablePrefix != "" && strings.HasPrefix(name, "tpt_") {
+		return s.options.TablePrefix + strings.TrimPrefix(name, "tpt_")
+	}
+	return name
+}
+
+func (s *Store) reuseDeletedNames() bool {
+	if s.options == nil {
+		return ReuseDeletedNames
+	}
+	return s.options.ReuseDeletedNames
+}
+
+func (s *Store) uniqueEmail() bool {
+	if s.options == nil {
+		return UniqueEmail
+	}
+	return s.options.UniqueEmail
+}
+
+func (s *Store) uniquePhone() bool {
+	if s.options == nil {
+		return UniquePhone
+	}
+	return s.options.UniquePhone
+}
@@ -0,0 +1,259 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		// Store 不受包级变量的影响
+		oldFormat, oldReturning := PlaceholderFormat, IsReturning
+		PlaceholderFormat, IsReturning = Question, false
+		defer func() {
+			PlaceholderFormat, IsReturning = oldFormat, oldReturning
+		}()
+
+		dialect, _ := DialectByName(*driverName)
+		strict := NewStore(db, dialect, nil)
+		reuse := NewStore(db, dialect, &StoreOptions{ReuseDeletedNames: true})
+		if strict.DB() != db || strict.Dialect() != dialect {
+			t.Error("db or dialect is wrong")
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 10; i++ {
+			for _, store := range []*Store{strict, reuse} {
+				wg.Add(1)
+				go func(store *Store, name string) {
+					defer wg.Done()
+					user := &User{Name: name}
+					if _, err := store.Users.CreateIt(db, user); err != nil {
+						errs <- err
+						return
+					}
+					if err := store.Profiles.Set(db, name, "theme", "dark"); err != nil {
+						errs <- err
+					}
+				}(store, fmt.Sprintf("store_%p_%d", store, i))
+			}
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		users, err := strict.Users.QueryWith(db, "WHERE name LIKE ?", "store_%")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(users) != 20 {
+			t.Error("want 20 users, got", len(users))
+		}
+
+		role := &Role{Name: "store_role"}
+		if _, err := strict.Roles.CreateIt(db, role); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := strict.Roles.DeleteByID(db, role.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := strict.Roles.CreateIt(db, &Role{Name: "store_role"}); err == nil {
+			t.Error("want a DuplicateError, got nil")
+		} else if _, ok := err.(*DuplicateError); !ok {
+			t.Error(err)
+		}
+		if _, err := reuse.Roles.CreateIt(db, &Role{Name: "store_role"}); err != nil {
+			t.Error(err)
+		}
+
+		if _, err := strict.Roles.WithDeleted().FindByID(db, role.ID); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// recordExecutor 记录执行的 SQL 语句, 不访问数据库
+type recordExecutor struct {
+	queries []string
+}
+
+var errRecorded = errors.New("recorded")
+
+func (e *recordExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	return nil, errRecorded
+}
+
+func (e *recordExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	e.queries = append(e.queries, query)
+	return nil, errRecorded
+}
+
+func (e *recordExecutor) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	panic("QueryRowContext isn't supported")
+}
+
+func TestStoreTableNames(t *testing.T) {
+	store := NewStore(nil, Postgres, &StoreOptions{
+		TablePrefix: "app_",
+		Schema:      "auth",
+		Tables:      map[string]string{"tpt_user_profiles": "preferences"},
+	})
+	for _, test := range []struct {
+		name   string
+		table  string
+		object string
+	}{
+		{"tpt_users", "auth.app_users", "app_users"},
+		{"tpt_user_profiles", "auth.preferences", "preferences"},
+		{"tpt_users_email_uq", "", "app_users_email_uq"},
+	} {
+		if test.table != "" {
+			if table := store.TableName(test.name); table != test.table {
+				t.Errorf("want %s, got %s", test.table, table)
+			}
+		}
+		if name := store.objectName(test.name); name != test.object {
+			t.Errorf("want %s, got %s", test.object, name)
+		}
+	}
+	if table := DefaultStore.TableName("tpt_users"); table != "tpt_users" {
+		t.Error("want tpt_users, got", table)
+	}
+
+	// 调用者传入的条件原样保留, 包括字符串中的 tpt_, 其中的 tpt_users 是查询中固定的别名
+	for _, test := range []struct {
+		query func(db Executor) error
+		want  string
+	}{
+		{func(db Executor) error {
+			_, err := store.Users.QueryWith(db, "WHERE tpt_users.description = 'tpt_roles' AND tpt_users.name = ?", "x")
+			return err
+		}, userPrefix + "(SELECT * FROM auth.app_users WHERE deleted_at IS NULL) tpt_users" +
+			" WHERE tpt_users.description = 'tpt_roles' AND tpt_users.name = $1"},
+		{func(db Executor) error {
+			_, err := store.Users.WithDeleted().QueryWith(db, "WHERE tpt_users.name LIKE 'tpt!_%' ESCAPE '!'")
+			return err
+		}, userPrefix + "auth.app_users tpt_users WHERE tpt_users.name LIKE 'tpt!_%' ESCAPE '!'"},
+		{func(db Executor) error {
+			_, err := store.Roles.FindByUserID(db, 1)
+			return err
+		}, rolePrefix + "(SELECT * FROM auth.app_roles WHERE deleted_at IS NULL) tpt_roles" +
+			" WHERE EXISTS (SELECT * FROM auth.app_user_roles tpt_user_roles WHERE user_id = $1 AND tpt_roles.id = tpt_user_roles.role_id)"},
+	} {
+		var exec recordExecutor
+		if err := test.query(&exec); err != errRecorded {
+			t.Error("want errRecorded, got", err)
+			continue
+		}
+		if len(exec.queries) != 1 || exec.queries[0] != test.want {
+			t.Errorf("want %q\n got %q", test.want, exec.queries)
+		}
+	}
+}
+
+func TestStoreTablePrefix(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		dialect, _ := DialectByName(*driverName)
+		store := NewStore(db, dialect, &StoreOptions{
+			TablePrefix: "app_",
+			Tables:      map[string]string{"tpt_user_profiles": "app_preferences"},
+		})
+		if err := dropSchema(db, store); err != nil {
+			t.Error(err)
+			return
+		}
+		defer dropSchema(db, store)
+		if err := store.Migrate(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+
+		role := &Role{Name: "prefix_role", PermissionKeys: "prefix"}
+		if _, err := store.Roles.CreateIt(db, role); err != nil {
+			t.Error(err)
+			return
+		}
+		user := &User{Name: "prefix_user"}
+		if _, err := store.Users.CreateIt(db, user); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := store.Users.AddRole(db, user.ID, role.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		rbac, err := store.QueryUserRBACContext(ctx, db, "prefix_user")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !rbac.HasPermission("prefix") {
+			t.Error("role isn't added")
+		}
+
+		if _, err := store.Users.CreateIt(db, &User{Name: "prefix_user"}); err == nil {
+			t.Error("want a DuplicateError, got nil")
+		} else if e, ok := err.(*DuplicateError); !ok || e.Field != "name" {
+			t.Error("want a DuplicateError of name, got", err)
+		}
+
+		if err := store.Profiles.Set(db, "prefix_user", "theme", "dark"); err != nil {
+			t.Error(err)
+			return
+		}
+		if value, err := store.Profiles.Get(db, "prefix_user", "theme"); err != nil || value != "dark" {
+			t.Error("want dark, got", value, err)
+		}
+
+		page, err := store.Users.List(db, ListOptions{Role: "prefix_role"})
+		if err != nil {
+			t.Error(err)
+		} else if page.Total != 1 {
+			t.Error("want 1 user, got", page.Total)
+		}
+
+		// 字符串中的 tpt_ 不是表名, 不会被改写
+		user.Description = "tpt_users"
+		if err := store.Users.UpdateIt(db, user); err != nil {
+			t.Error(err)
+			return
+		}
+		if users, err := store.Users.QueryWith(db, "WHERE tpt_users.description = 'tpt_users'"); err != nil {
+			t.Error(err)
+		} else if len(users) != 1 {
+			t.Error("want 1 user, got", len(users))
+		}
+
+		// 使用 store 的表校验密码
+		if err := store.Users.SetPassword(db, user.ID, "prefix_pwd"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := store.DBAuthenticator().Authenticate(ctx, db, "prefix_user", "prefix_pwd", "", ""); err != nil {
+			t.Error(err)
+		}
+		if _, err := DBAuthenticator.Authenticate(ctx, db, "prefix_user", "prefix_pwd", "", ""); err != ErrUserNotFound {
+			t.Error("want ErrUserNotFound, got", err)
+		}
+
+		var count int64
+		if err := db.QueryRow("SELECT count(*) FROM app_preferences").Scan(&count); err != nil || count != 1 {
+			t.Error("want 1, got", count, err)
+		}
+		if err := db.QueryRow("SELECT count(*) FROM tpt_users").Scan(&count); err != nil || count != 0 {
+			t.Error("want 0, got", count, err)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package permissions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrTokenInvalid - 表示访问令牌不存在或已被吊销
+	ErrTokenInvalid = errors.New("token is invalid")
+
+	// ErrTokenExpired - 表示访问令牌已过期
+	ErrTokenExpired = errors.New("token is expired")
+)
+
+// accessTokenMark 是访问令牌的前缀, 方便在日志和代码中识别出泄漏的令牌
+const accessTokenMark = "tpt_"
+
+// AccessToken 代表用户创建的访问令牌(API Key), 数据库中只保存令牌的摘要
+type AccessToken struct {
+	ID         int64     `json:"id,omitempty"`
+	UserID     int64     `json:"user_id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateTokenContext 为用户创建一个访问令牌, 令牌的明文只在这里返回一次。
+//
+// scopes 为空时令牌拥有用户的全部权限, 否则只拥有 scopes 与用户权限的交集;
+// expiresAt 为零值时令牌永不过期。
+func (self *users) CreateTokenContext(ctx context.Context, db Executor, userID int64, name string, scopes []string, expiresAt time.Time) (string, *AccessToken, error) {
+	if 0 == userID {
+		return "", nil, ThrowPrimaryKeyInvalid("tpt_users")
+	}
+
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", nil, err
+	}
+	token := accessTokenMark + base64.RawURLEncoding.EncodeToString(bs)
+
+	var nullScopes sql.NullString
+	if len(scopes) > 0 {
+		data, err := json.Marshal(scopes)
+		if err != nil {
+			return "", nil, err
+		}
+		nullScopes = sql.NullString{String: string(data), Valid: true}
+	}
+	var nullExpiresAt sql.NullTime
+	if !expiresAt.IsZero() {
+		nullExpiresAt = sql.NullTime{Time: expiresAt, Valid: true}
+	}
+
+	value := &AccessToken{
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	id, err := self.store.insertWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_user_tokens")+"(user_id, name, token_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID,
+		name,
+		hashToken(token),
+		nullScopes,
+		nullExpiresAt,
+		value.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	value.ID = id
+	return token, value, nil
+}
+
+// CreateToken 使用 context.Background() 调用 CreateTokenContext
+func (self *users) CreateToken(db Executor, userID int64, name string, scopes []string, expiresAt time.Time) (string, *AccessToken, error) {
+	return self.CreateTokenContext(context.Background(), db, userID, name, scopes, expiresAt)
+}
+
+const accessTokenPrefix = "SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at FROM "
+
+func scanToken(scanner RowScanner) (*AccessToken, error) {
+	var value AccessToken
+	var nullName sql.NullString
+	var nullScopes sql.NullString
+	var nullExpiresAt sql.NullTime
+	var nullLastUsedAt sql.NullTime
+	var nullCreatedAt sql.NullTime
+
+	e := scanner.Scan(
+		&value.ID,
+		&value.UserID,
+		&nullName,
+		&nullScopes,
+		&nullExpiresAt,
+		&nullLastUsedAt,
+		&nullCreatedAt)
+	if nil != e {
+		return nil, e
+	}
+
+	if nullName.Valid {
+		value.Name = nullName.String
+	}
+	if nullScopes.Valid && nullScopes.String != "" {
+		if err := json.Unmarshal([]byte(nullScopes.String), &value.Scopes); err != nil {
+			return nil, errors.New("scopes of token '" + value.Name + "' is invalid, " + err.Error())
+		}
+	}
+	if nullExpiresAt.Valid {
+		value.ExpiresAt = nullExpiresAt.Time
+	}
+	if nullLastUsedAt.Valid {
+		value.LastUsedAt = nullLastUsedAt.Time
+	}
+	if nullCreatedAt.Valid {
+		value.CreatedAt = nullCreatedAt.Time
+	}
+	return &value, nil
+}
+
+// ListTokensContext 列出用户的所有访问令牌
+func (self *users) ListTokensContext(ctx context.Context, db Executor, userID int64) ([]*AccessToken, error) {
+	queryString, err := self.store.rebind(accessTokenPrefix + self.store.TableName("tpt_user_tokens") + " WHERE user_id = ? ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, queryString, userID)
+	if nil != err {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*AccessToken, 0, 4)
+	for rows.Next() {
+		v, err := scanToken(rows)
+		if nil != err {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, rows.Err()
+}
+
+// ListTokens 使用 context.Background() 调用 ListTokensContext
+func (self *users) ListTokens(db Executor, userID int64) ([]*AccessToken, error) {
+	return self.ListTokensContext(context.Background(), db, userID)
+}
+
+// RevokeTokenContext 吊销用户的一个访问令牌
+func (self *users) RevokeTokenContext(ctx context.Context, db Executor, userID, tokenID int64) error {
+	deleteString, err := self.store.rebind("DELETE FROM " + self.store.TableName("tpt_user_tokens") + " WHERE id = ? AND user_id = ?")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, deleteString, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotDeleted
+	}
+	return nil
+}
+
+// RevokeToken 使用 context.Background() 调用 RevokeTokenContext
+func (self *users) RevokeToken(db Executor, userID, tokenID int64) error {
+	return self.RevokeTokenContext(context.Background(), db, userID, tokenID)
+}
+
+// QueryTokenRBACContext 按访问令牌查询令牌所有者的权限, 令牌的权限是所有者的权限与令牌的 scopes 的交集
+func (s *Store) QueryTokenRBACContext(ctx context.Context, db Executor, token string) (*UserRBAC, error) {
+	queryString, err := s.rebind(accessTokenPrefix + s.TableName("tpt_user_tokens") + " WHERE token_hash = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := scanToken(db.QueryRowContext(ctx, queryString, hashToken(token)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !value.ExpiresAt.IsZero() && !now.Before(value.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	if err := s.execWith(ctx, db, "UPDATE "+s.TableName("tpt_user_tokens")+" SET last_used_at = ? WHERE id = ?", now, value.ID); err != nil {
+		return nil, err
+	}
+
+	user, err := s.Users.FindByIDContext(ctx, db, value.UserID)
+	if err != nil {
+		return nil, errors.New("load user fial, " + err.Error())
+	}
+	rbac, err := s.queryRBAC(ctx, db, user)
+	if err != nil {
+		return nil, err
+	}
+	if len(value.Scopes) > 0 {
+		rbac.scopes = map[string]struct{}{}
+		for _, key := range value.Scopes {
+			rbac.scopes[key] = struct{}{}
+		}
+	}
+	return rbac, nil
+}
+
+// QueryTokenRBACContext 使用 DefaultStore, 见 Store.QueryTokenRBACContext
+func QueryTokenRBACContext(ctx context.Context, db Executor, token string) (*UserRBAC, error) {
+	return DefaultStore.QueryTokenRBACContext(ctx, db, token)
+}
+
+// QueryTokenRBAC 使用 context.Background() 调用 QueryTokenRBACContext
+func QueryTokenRBAC(db Executor, token string) (*UserRBAC, error) {
+	return QueryTokenRBACContext(context.Background(), db, token)
+}
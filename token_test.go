@@ -0,0 +1,121 @@
+package permissions
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessToken(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		role1 := &Role{
+			Name:           "token_r1",
+			PermissionKeys: "k1,k2,k3",
+		}
+		user1 := &User{
+			Name: "token1",
+		}
+		if _, err := role1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.AddRole(db, user1.ID, role1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+
+		token1, value1, err := Users.CreateToken(db, user1.ID, "all", nil, time.Time{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.HasPrefix(token1, accessTokenMark) {
+			t.Error(token1)
+		}
+		token2, _, err := Users.CreateToken(db, user1.ID, "scoped", []string{"k1", "k3", "k9"}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		token3, _, err := Users.CreateToken(db, user1.ID, "expired", nil, time.Now().Add(-time.Second))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		rbac, err := QueryTokenRBAC(db, token1)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if rbac.User.ID != user1.ID {
+			t.Error(rbac.User.ID, user1.ID)
+		}
+		for _, key := range []string{"k1", "k2", "k3"} {
+			if !rbac.HasPermission(key) {
+				t.Error(key, "isn't found")
+			}
+		}
+
+		rbac, err = QueryTokenRBAC(db, token2)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for key, expected := range map[string]bool{"k1": true, "k2": false, "k3": true, "k9": false} {
+			if rbac.HasPermission(key) != expected {
+				t.Error(key, expected)
+			}
+		}
+		if keys := rbac.PermissionKeys(); len(keys) != 2 {
+			t.Error(keys)
+		}
+
+		if _, err := QueryTokenRBAC(db, token3); err != ErrTokenExpired {
+			t.Error("want ErrTokenExpired, got", err)
+		}
+		if _, err := QueryTokenRBAC(db, token1+"x"); err != ErrTokenInvalid {
+			t.Error("want ErrTokenInvalid, got", err)
+		}
+
+		tokens, err := Users.ListTokens(db, user1.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(tokens) != 3 {
+			t.Error(len(tokens))
+			return
+		}
+		if tokens[0].Name != "all" || tokens[0].LastUsedAt.IsZero() {
+			t.Error(tokens[0])
+		}
+		if tokens[1].Name != "scoped" || len(tokens[1].Scopes) != 3 || tokens[1].ExpiresAt.IsZero() {
+			t.Error(tokens[1])
+		}
+		if !tokens[2].LastUsedAt.IsZero() {
+			t.Error(tokens[2])
+		}
+
+		if err := Users.RevokeToken(db, user1.ID, value1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := QueryTokenRBAC(db, token1); err != ErrTokenInvalid {
+			t.Error("want ErrTokenInvalid, got", err)
+		}
+
+		if err := Users.Disable(db, user1.ID, "admin", "left"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := QueryTokenRBAC(db, token2); err != ErrUserDisabled {
+			t.Error("want ErrUserDisabled, got", err)
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package permissions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSecondFactorRequired - 表示用户已启用两步验证, 需要提供动态口令
+	ErrSecondFactorRequired = errors.New("second factor is required")
+
+	// ErrTOTPNotEnrolled - 表示用户没有启用两步验证
+	ErrTOTPNotEnrolled = errors.New("totp isn't enrolled")
+
+	// ErrTOTPAlreadyEnrolled - 表示用户已经启用了两步验证
+	ErrTOTPAlreadyEnrolled = errors.New("totp is already enrolled")
+
+	// ErrTOTPCodeIncorrect - 表示动态口令或恢复码不正确, 或者已经被使用过
+	ErrTOTPCodeIncorrect = errors.New("totp code is incorrect")
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30
+)
+
+var (
+	// TOTPSkew 校验动态口令时允许前后偏差的时间步数
+	TOTPSkew int64 = 1
+
+	// RecoveryCodeCount 启用两步验证时生成的恢复码个数
+	RecoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPCode 按 RFC 6238 计算 secret 在 t 时刻的动态口令
+func GenerateTOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+// hotp 按 RFC 4226 计算动态口令
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPEnrollment 代表启用两步验证时生成的信息, 恢复码只在这里返回一次
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// EnrollTOTPContext 为用户生成动态口令的密钥和恢复码, 需要用 ConfirmTOTP 确认后才会生效
+func (self *users) EnrollTOTPContext(ctx context.Context, db Executor, userID int64, issuer string) (*TOTPEnrollment, error) {
+	user, err := self.FindByIDContext(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := self.isTOTPEnabled(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return nil, ErrTOTPAlreadyEnrolled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	enrollment := &TOTPEnrollment{
+		Secret: totpEncoding.EncodeToString(key),
+	}
+	enrollment.URI = totpURI(issuer, user.Name, enrollment.Secret)
+
+	if err := self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_recovery_codes")+" WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	columns := []string{"user_id", "secret", "confirmed", "last_counter", "created_at", "updated_at"}
+	if upsertString := self.store.dialect.Upsert(self.store.TableName("tpt_user_totp"), columns[:1], columns, columns[1:]); upsertString != "" {
+		err = self.store.execWith(ctx, db, upsertString, userID, enrollment.Secret, false, 0, now, now)
+	} else {
+		err = self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_totp")+" WHERE user_id = ?", userID)
+		if err == nil {
+			err = self.store.execWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_user_totp")+"(user_id, secret, confirmed, last_counter, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+				userID, enrollment.Secret, false, 0, now, now)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = self.store.execWith(ctx, db, "INSERT INTO "+self.store.TableName("tpt_user_recovery_codes")+"(user_id, code_hash, created_at) VALUES (?, ?, ?)",
+			userID, hashRecoveryCode(code), now)
+		if err != nil {
+			return nil, err
+		}
+		enrollment.RecoveryCodes = append(enrollment.RecoveryCodes, code)
+	}
+	return enrollment, nil
+}
+
+// EnrollTOTP 使用 context.Background() 调用 EnrollTOTPContext
+func (self *users) EnrollTOTP(db Executor, userID int64, issuer string) (*TOTPEnrollment, error) {
+	return self.EnrollTOTPContext(context.Background(), db, userID, issuer)
+}
+
+func totpURI(issuer, account, secret string) string {
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+	params := url.Values{}
+	params.Set("secret", secret)
+	if issuer != "" {
+		params.Set("issuer", issuer)
+	}
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(label) + "?" + params.Encode()
+}
+
+func generateRecoveryCode() (string, error) {
+	bs := make([]byte, 5)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(bs))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ConfirmTOTPContext 用一个动态口令确认启用两步验证
+func (self *users) ConfirmTOTPContext(ctx context.Context, db Executor, userID int64, code string) error {
+	secret, confirmed, lastCounter, err := self.readTOTP(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if confirmed {
+		return ErrTOTPAlreadyEnrolled
+	}
+	if err := self.useTOTPCode(ctx, db, userID, secret, lastCounter, code); err != nil {
+		return err
+	}
+	return self.store.execWith(ctx, db, "UPDATE "+self.store.TableName("tpt_user_totp")+" SET confirmed = ?, updated_at = ? WHERE user_id = ?",
+		true, time.Now(), userID)
+}
+
+// ConfirmTOTP 使用 context.Background() 调用 ConfirmTOTPContext
+func (self *users) ConfirmTOTP(db Executor, userID int64, code string) error {
+	return self.ConfirmTOTPContext(context.Background(), db, userID, code)
+}
+
+// DisableTOTPContext 停用用户的两步验证, 并删除所有的恢复码
+func (self *users) DisableTOTPContext(ctx context.Context, db Executor, userID int64) error {
+	if err := self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_recovery_codes")+" WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return self.store.execWith(ctx, db, "DELETE FROM "+self.store.TableName("tpt_user_totp")+" WHERE user_id = ?", userID)
+}
+
+// DisableTOTP 使用 context.Background() 调用 DisableTOTPContext
+func (self *users) DisableTOTP(db Executor, userID int64) error {
+	return self.DisableTOTPContext(context.Background(), db, userID)
+}
+
+// VerifyTOTPContext 校验动态口令或恢复码, 动态口令和恢复码都只能使用一次
+func (self *users) VerifyTOTPContext(ctx context.Context, db Executor, userID int64, code string) error {
+	secret, confirmed, lastCounter, err := self.readTOTP(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return ErrTOTPNotEnrolled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return self.useTOTPCode(ctx, db, userID, secret, lastCounter, code)
+	}
+	return self.useRecoveryCode(ctx, db, userID, code)
+}
+
+// VerifyTOTP 使用 context.Background() 调用 VerifyTOTPContext
+func (self *users) VerifyTOTP(db Executor, userID int64, code string) error {
+	return self.VerifyTOTPContext(context.Background(), db, userID, code)
+}
+
+func (self *users) isTOTPEnabled(ctx context.Context, db Executor, userID int64) (bool, error) {
+	_, confirmed, _, err := self.readTOTP(ctx, db, userID)
+	if err != nil {
+		if err == ErrTOTPNotEnrolled {
+			return false, nil
+		}
+		return false, err
+	}
+	return confirmed, nil
+}
+
+func (self *users) readTOTP(ctx context.Context, db Executor, userID int64) (secret string, confirmed bool, lastCounter int64, err error) {
+	queryString, err := self.store.rebind("SELECT secret, confirmed, last_counter FROM " + self.store.TableName("tpt_user_totp") + " WHERE user_id = ?")
+	if err != nil {
+		return "", false, 0, err
+	}
+	err = db.QueryRowContext(ctx, queryString, userID).Scan(&secret, &confirmed, &lastCounter)
+	if err == sql.ErrNoRows {
+		err = ErrTOTPNotEnrolled
+	}
+	return secret, confirmed, lastCounter, err
+}
+
+// useTOTPCode 在允许的时间偏差内查找匹配的时间步, 并且只接受比上次使用过的更新的时间步,
+// 以防止同一个动态口令被重放。
+func (self *users) useTOTPCode(ctx context.Context, db Executor, userID int64, secret string, lastCounter int64, code string) error {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return err
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if !hmac.Equal([]byte(hotp(key, uint64(counter))), []byte(code)) {
+			continue
+		}
+
+		updateString, err := self.store.rebind("UPDATE " + self.store.TableName("tpt_user_totp") + " SET last_counter = ? WHERE user_id = ? AND last_counter < ?")
+		if err != nil {
+			return err
+		}
+		result, err := db.ExecContext(ctx, updateString, counter, userID, counter)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrTOTPCodeIncorrect
+		}
+		return nil
+	}
+	return ErrTOTPCodeIncorrect
+}
+
+func (self *users) useRecoveryCode(ctx context.Context, db Executor, userID int64, code string) error {
+	deleteString, err := self.store.rebind("DELETE FROM " + self.store.TableName("tpt_user_recovery_codes") + " WHERE user_id = ? AND code_hash = ?")
+	if err != nil {
+		return err
+	}
+	result, err := db.ExecContext(ctx, deleteString, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPCodeIncorrect
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package permissions
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 附录 B 中 SHA1 的测试向量, 取后 6 位
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, test := range []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	} {
+		code, err := GenerateTOTPCode(secret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if code != test.code {
+			t.Error(test.unix, code, test.code)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		user1 := &User{
+			Name:     "totp1",
+			Password: "totp1_pwd",
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		enrollment, err := Users.EnrollTOTP(db, user1.ID, "tpt")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.HasPrefix(enrollment.URI, "otpauth://totp/tpt:totp1?") ||
+			!strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+			t.Error(enrollment.URI)
+		}
+		if len(enrollment.RecoveryCodes) != RecoveryCodeCount {
+			t.Error(len(enrollment.RecoveryCodes))
+		}
+
+		// 确认之前不需要动态口令
+		if _, err := Authenticate(db, "totp1", "totp1_pwd"); err != nil {
+			t.Error(err)
+		}
+
+		if err := Users.ConfirmTOTP(db, user1.ID, "000000x"); err != ErrTOTPCodeIncorrect {
+			t.Error("want ErrTOTPCodeIncorrect, got", err)
+		}
+		code, err := GenerateTOTPCode(enrollment.Secret, time.Now())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Users.ConfirmTOTP(db, user1.ID, code); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Users.EnrollTOTP(db, user1.ID, "tpt"); err != ErrTOTPAlreadyEnrolled {
+			t.Error("want ErrTOTPAlreadyEnrolled, got", err)
+		}
+
+		if _, err := Authenticate(db, "totp1", "totp1_pwd"); err != ErrSecondFactorRequired {
+			t.Error("want ErrSecondFactorRequired, got", err)
+		}
+		if _, err := AuthenticateWithCode(db, "totp1", "bad", code); err != ErrPasswordIncorrect {
+			t.Error("want ErrPasswordIncorrect, got", err)
+		}
+
+		// 已使用过的动态口令不能再次使用
+		if _, err := AuthenticateWithCode(db, "totp1", "totp1_pwd", code); err != ErrTOTPCodeIncorrect {
+			t.Error("want ErrTOTPCodeIncorrect, got", err)
+		}
+
+		code, err = GenerateTOTPCode(enrollment.Secret, time.Now().Add(totpPeriod*time.Second))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := AuthenticateWithCode(db, "totp1", "totp1_pwd", code); err != nil {
+			t.Error(err)
+		}
+
+		recoveryCode := strings.ToUpper(enrollment.RecoveryCodes[0])
+		if _, err := AuthenticateWithCode(db, "totp1", "totp1_pwd", recoveryCode); err != nil {
+			t.Error(err)
+		}
+		if _, err := AuthenticateWithCode(db, "totp1", "totp1_pwd", recoveryCode); err != ErrTOTPCodeIncorrect {
+			t.Error("want ErrTOTPCodeIncorrect, got", err)
+		}
+
+		if err := Users.DisableTOTP(db, user1.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Authenticate(db, "totp1", "totp1_pwd"); err != nil {
+			t.Error(err)
+		}
+	})
+}
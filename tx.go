@@ -0,0 +1,113 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type txConfig struct {
+	options    *sql.TxOptions
+	maxRetries int
+}
+
+// TxOption 是 InTx 的选项
+type TxOption func(*txConfig)
+
+// WithTxOptions 指定事务的隔离级别和是否只读
+func WithTxOptions(options *sql.TxOptions) TxOption {
+	return func(cfg *txConfig) {
+		cfg.options = options
+	}
+}
+
+// WithRetry 在事务因序列化失败或死锁而失败时最多重试 maxRetries 次,
+// 重试时会重新执行整个 fn, 所以 fn 中不应有数据库以外的副作用。
+func WithRetry(maxRetries int) TxOption {
+	return func(cfg *txConfig) {
+		cfg.maxRetries = maxRetries
+	}
+}
+
+// InTx 在一个事务中执行 fn, fn 返回错误或 panic 时回滚事务, 否则提交事务, 例如
+//
+//	err := InTx(ctx, db, func(tx *sql.Tx) error {
+//		if _, err := Users.CreateItContext(ctx, tx, user); err != nil {
+//			return err
+//		}
+//		return Users.AddRoleContext(ctx, tx, user.ID, role.ID)
+//	})
+func InTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error, opts ...TxOption) error {
+	var cfg txConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, cfg.options, fn)
+		if err == nil || attempt >= cfg.maxRetries || !isSerializationFailure(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 10 * time.Millisecond):
+		}
+	}
+}
+
+// inTx 在 db 是 *sql.DB 或 *sql.Conn 时在一个新的事务中执行 fn, 否则认为调用者已经开启了
+// 事务(例如 db 是 *sql.Tx), 直接执行 fn, 用于 DAO 中需要执行多条语句的方法。
+func inTx(ctx context.Context, db Executor, fn func(db Executor) error) error {
+	switch conn := db.(type) {
+	case *sql.DB:
+		return InTx(ctx, conn, func(tx *sql.Tx) error {
+			return fn(tx)
+		})
+	case *sql.Conn:
+		return runTx(ctx, conn, nil, func(tx *sql.Tx) error {
+			return fn(tx)
+		})
+	default:
+		return fn(db)
+	}
+}
+
+// startsTx 判断 inTx 是否会为 db 开启新的事务
+func startsTx(db Executor) bool {
+	switch db.(type) {
+	case *sql.DB, *sql.Conn:
+		return true
+	default:
+		return false
+	}
+}
+
+// txBeginner 是 *sql.DB 和 *sql.Conn 共有的开启事务的方法
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+func runTx(ctx context.Context, db txBeginner, options *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
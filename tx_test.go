@@ -0,0 +1,96 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestInTx(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		ctx := context.Background()
+		role1 := &Role{Name: "tx_role1", PermissionKeys: "tx"}
+		if _, err := role1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		err := InTx(ctx, db, func(tx *sql.Tx) error {
+			user := &User{Name: "tx1"}
+			if _, err := user.CreateItContext(ctx, tx); err != nil {
+				return err
+			}
+			return Users.AddRoleContext(ctx, tx, user.ID, role1.ID)
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		rbac, err := QueryUserRBAC(db, "tx1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !rbac.HasPermission("tx") {
+			t.Error("role isn't added")
+		}
+
+		errFail := errors.New("fail")
+		err = InTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := (&User{Name: "tx2"}).CreateItContext(ctx, tx); err != nil {
+				return err
+			}
+			return errFail
+		})
+		if err != errFail {
+			t.Error("want errFail, got", err)
+		}
+		if _, err := Users.FindByName(db, "tx2"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("panic is lost")
+				}
+			}()
+			InTx(ctx, db, func(tx *sql.Tx) error {
+				if _, err := (&User{Name: "tx3"}).CreateItContext(ctx, tx); err != nil {
+					return err
+				}
+				panic("tx3")
+			})
+		}()
+		if _, err := Users.FindByName(db, "tx3"); err != sql.ErrNoRows {
+			t.Error("want sql.ErrNoRows, got", err)
+		}
+
+		attempts := 0
+		err = InTx(ctx, db, func(tx *sql.Tx) error {
+			attempts++
+			if attempts < 3 {
+				return &pq.Error{Code: "40001"}
+			}
+			return nil
+		}, WithRetry(5))
+		if err != nil {
+			t.Error(err)
+		}
+		if attempts != 3 {
+			t.Error("want 3, got", attempts)
+		}
+
+		attempts = 0
+		err = InTx(ctx, db, func(tx *sql.Tx) error {
+			attempts++
+			return &pq.Error{Code: "40001"}
+		})
+		if err == nil || attempts != 1 {
+			t.Error("retry without WithRetry", attempts, err)
+		}
+	})
+}
@@ -2,10 +2,11 @@ package permissions
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 )
@@ -37,7 +38,26 @@ var (
 
 	// Dollar is a PlaceholderFormat instance that replaces placeholders with
 	// dollar-prefixed positional placeholders (e.g. $1, $2, $3).
-	Dollar = func(sql string) (string, error) {
+	Dollar = positional("$")
+
+	// AtP is a PlaceholderFormat instance that replaces placeholders with
+	// "@p"-prefixed positional placeholders (e.g. @p1, @p2, @p3), used by SQL Server.
+	AtP = positional("@p")
+
+	// Colon is a PlaceholderFormat instance that replaces placeholders with
+	// colon-prefixed positional placeholders (e.g. :1, :2, :3), used by Oracle.
+	Colon = positional(":")
+
+	// PlaceholderFormat takes a SQL statement and replaces each question mark
+	// placeholder with a (possibly different) SQL placeholder.
+	PlaceholderFormat = Question
+
+	// IsReturning use returning case in the insert statement.
+	IsReturning bool
+)
+
+func positional(prefix string) func(sql string) (string, error) {
+	return func(sql string) (string, error) {
 		buf := &bytes.Buffer{}
 		i := 0
 		for {
@@ -56,7 +76,7 @@ var (
 			} else {
 				i++
 				buf.WriteString(sql[:p])
-				fmt.Fprintf(buf, "$%d", i)
+				fmt.Fprintf(buf, "%s%d", prefix, i)
 				sql = sql[p+1:]
 			}
 		}
@@ -64,14 +84,7 @@ var (
 		buf.WriteString(sql)
 		return buf.String(), nil
 	}
-
-	// PlaceholderFormat takes a SQL statement and replaces each question mark
-	// placeholder with a (possibly different) SQL placeholder.
-	PlaceholderFormat = Question
-
-	// IsReturning use returning case in the insert statement.
-	IsReturning bool
-)
+}
 
 // Role 代表一个用户角色
 type Role struct {
@@ -81,13 +94,27 @@ type Role struct {
 	PermissionKeys string    `json:"permission_keys,omitempty"`
 	UpdatedAt      time.Time `json:"updated_at,omitempty"`
 	CreatedAt      time.Time `json:"created_at,omitempty"`
+	DeletedAt      time.Time `json:"deleted_at,omitempty"`
 }
 
-func (role *Role) Keys() {
-	var keys []string
-	if err := json.Unmarshal([]byte(role.PermissionKeys), &keys); err != nil {
+// Keys 返回角色的权限列表, PermissionKeys 可以是 JSON 数组或以逗号分隔的字符串
+func (role *Role) Keys() []string {
+	s := strings.TrimSpace(role.PermissionKeys)
+	if strings.HasPrefix(s, "[") {
+		var keys []string
+		if err := json.Unmarshal([]byte(s), &keys); err == nil {
+			return keys
+		}
+	}
 
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
 	}
+	return keys
 }
 
 // User 代表一个用户
@@ -101,64 +128,129 @@ type User struct {
 	State       int64     `json:"state,omitempty"`
 	CreatedAt   time.Time `json:"created_at,omitempty"`
 	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+
+	FailedAttempts     int64     `json:"failed_attempts,omitempty"`
+	LockedUntil        time.Time `json:"locked_until,omitempty"`
+	PasswordChangedAt  time.Time `json:"password_changed_at,omitempty"`
+	MustChangePassword bool      `json:"must_change_password,omitempty"`
+	DeletedAt          time.Time `json:"deleted_at,omitempty"`
+	LastLoginAt        time.Time `json:"last_login_at,omitempty"`
+	LastLoginIP        string    `json:"last_login_ip,omitempty"`
+}
+
+// 用户的状态, 状态之间的转换规则见 userStateTransitions
+const (
+	// UserActive 表示用户状态正常
+	UserActive int64 = 0
+	// UserDisabled 表示用户已被禁用
+	UserDisabled int64 = 1
+	// UserPending 表示用户已创建但尚未激活
+	UserPending int64 = 2
+	// UserLocked 表示用户被管理员锁定
+	UserLocked int64 = 3
+	// UserExpired 表示用户已过期
+	UserExpired int64 = 4
+)
+
+func (user *User) CreateItContext(ctx context.Context, db Executor) (int64, error) {
+	return Users.CreateItContext(ctx, db, user)
+}
+
+func (user *User) CreateIt(db Executor) (int64, error) {
+	return user.CreateItContext(context.Background(), db)
 }
 
-func (user *User) CreateIt(db *sql.DB) (int64, error) {
-	return Users.CreateIt(db, user)
+func (user *User) UpdateItContext(ctx context.Context, db Executor) error {
+	return Users.UpdateItContext(ctx, db, user)
 }
 
-func (user *User) UpdateIt(db *sql.DB) error {
-	return Users.UpdateIt(db, user)
+func (user *User) UpdateIt(db Executor) error {
+	return user.UpdateItContext(context.Background(), db)
 }
 
-func (user *User) DeleteIt(db *sql.DB) error {
-	return Users.DeleteIt(db, user)
+func (user *User) DeleteItContext(ctx context.Context, db Executor) error {
+	return Users.DeleteItContext(ctx, db, user)
+}
+
+func (user *User) DeleteIt(db Executor) error {
+	return user.DeleteItContext(context.Background(), db)
 }
 
 // UserProfile 代表用户的属性
 type UserProfile struct {
 	ID        int64     `json:"id,omitempty"`
+	UserID    int64     `json:"user_id,omitempty"`
 	User      string    `json:"usr,omitempty"`
 	Name      string    `json:"name,omitempty"`
 	Value     string    `json:"value,omitempty"`
+	Version   int64     `json:"version,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
 
-func (userProfile *UserProfile) CreateIt(db *sql.DB) (int64, error) {
-	return UserProfiles.CreateIt(db, userProfile)
+func (userProfile *UserProfile) CreateItContext(ctx context.Context, db Executor) (int64, error) {
+	return UserProfiles.CreateItContext(ctx, db, userProfile)
+}
+
+func (userProfile *UserProfile) CreateIt(db Executor) (int64, error) {
+	return userProfile.CreateItContext(context.Background(), db)
+}
+
+func (userProfile *UserProfile) UpdateItContext(ctx context.Context, db Executor) error {
+	return UserProfiles.UpdateItContext(ctx, db, userProfile)
+}
+
+func (userProfile *UserProfile) UpdateIt(db Executor) error {
+	return userProfile.UpdateItContext(context.Background(), db)
 }
 
-func (userProfile *UserProfile) UpdateIt(db *sql.DB) error {
-	return UserProfiles.UpdateIt(db, userProfile)
+func (userProfile *UserProfile) DeleteItContext(ctx context.Context, db Executor) error {
+	return UserProfiles.DeleteItContext(ctx, db, userProfile)
 }
 
-func (userProfile *UserProfile) DeleteIt(db *sql.DB) error {
-	return UserProfiles.DeleteIt(db, userProfile)
+func (userProfile *UserProfile) DeleteIt(db Executor) error {
+	return userProfile.DeleteItContext(context.Background(), db)
 }
 
-func (role *Role) CreateIt(db *sql.DB) (int64, error) {
-	return Roles.CreateIt(db, role)
+func (role *Role) CreateItContext(ctx context.Context, db Executor) (int64, error) {
+	return Roles.CreateItContext(ctx, db, role)
 }
 
-func (role *Role) UpdateIt(db *sql.DB) error {
-	return Roles.UpdateIt(db, role)
+func (role *Role) CreateIt(db Executor) (int64, error) {
+	return role.CreateItContext(context.Background(), db)
 }
 
-func (role *Role) DeleteIt(db *sql.DB) error {
-	return Roles.DeleteIt(db, role)
+func (role *Role) UpdateItContext(ctx context.Context, db Executor) error {
+	return Roles.UpdateItContext(ctx, db, role)
 }
 
+func (role *Role) UpdateIt(db Executor) error {
+	return role.UpdateItContext(context.Background(), db)
+}
+
+func (role *Role) DeleteItContext(ctx context.Context, db Executor) error {
+	return Roles.DeleteItContext(ctx, db, role)
+}
+
+func (role *Role) DeleteIt(db Executor) error {
+	return role.DeleteItContext(context.Background(), db)
+}
+
+// Roles, Users 和 UserProfiles 是 DefaultStore 的数据访问对象
 var (
-	Roles        = roles{}
-	Users        = users{}
-	UserProfiles = userProfiles{}
+	Roles        = DefaultStore.Roles
+	Users        = DefaultStore.Users
+	UserProfiles = DefaultStore.Profiles
 )
 
 // User 代表一个用户
 type UserRBAC struct {
-	User User
-	//Role []Role
+	User  User
+	Roles []string
+
+	permissions map[string]struct{}
+	// scopes 不为 nil 时只允许其中的权限, 见 QueryTokenRBAC
+	scopes map[string]struct{}
 }
 
 func (self *UserRBAC) Name() string {
@@ -170,29 +262,72 @@ func (self *UserRBAC) IsAdmin() bool {
 }
 
 func (self *UserRBAC) HasPermission(key string) bool {
-	panic("not implemented")
+	if self.scopes != nil {
+		if _, ok := self.scopes[key]; !ok {
+			return false
+		}
+	}
+	if self.IsAdmin() {
+		return true
+	}
+	_, ok := self.permissions[key]
+	return ok
+}
+
+// PermissionKeys 返回用户拥有的所有权限
+func (self *UserRBAC) PermissionKeys() []string {
+	keys := make([]string, 0, len(self.permissions))
+	for key := range self.permissions {
+		if self.scopes != nil {
+			if _, ok := self.scopes[key]; !ok {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func (self *UserRBAC) Data() interface{} {
 	panic("not implemented")
 }
 
-func QueryUserRBAC(db *sql.DB, userName string) (*UserRBAC, error) {
-	user, err := Users.FindByName(db, userName)
+func (s *Store) QueryUserRBACContext(ctx context.Context, db Executor, userName string) (*UserRBAC, error) {
+	user, err := s.Users.FindByNameContext(ctx, db, userName)
 	if err != nil {
 		return nil, errors.New("load user fial, " + err.Error())
 	}
-	roles, err := Users.ListRoles(db, user.ID)
+	return s.queryRBAC(ctx, db, user)
+}
+
+// QueryUserRBACContext 使用 DefaultStore, 见 Store.QueryUserRBACContext
+func QueryUserRBACContext(ctx context.Context, db Executor, userName string) (*UserRBAC, error) {
+	return DefaultStore.QueryUserRBACContext(ctx, db, userName)
+}
+
+func QueryUserRBAC(db Executor, userName string) (*UserRBAC, error) {
+	return QueryUserRBACContext(context.Background(), db, userName)
+}
+
+func (s *Store) queryRBAC(ctx context.Context, db Executor, user *User) (*UserRBAC, error) {
+	if err := userStateError(user.State); err != nil {
+		return nil, err
+	}
+	roles, err := s.Users.ListRolesContext(ctx, db, user.ID)
 	if err != nil {
 		return nil, errors.New("load roles fial, " + err.Error())
 	}
 
 	rbac := &UserRBAC{
-		User: *user,
+		User:        *user,
+		permissions: map[string]struct{}{},
 	}
 	for _, r := range roles {
-		fmt.Println(r.Name)
-		panic("not implemented")
+		rbac.Roles = append(rbac.Roles, r.Name)
+		for _, key := range r.Keys() {
+			rbac.permissions[key] = struct{}{}
+		}
 	}
 
 	return rbac, nil
@@ -1,88 +1,82 @@
 package permissions
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
 )
 
-var driverName = flag.String("dbDrv", "postgres", "")
-var dataSourceName = flag.String("dbURL", "host=127.0.0.1 dbname=tpt_data_test user=xxx password=xxx sslmode=disable", "")
+// 测试默认在内存中的 SQLite 数据库上运行, 在 PostgreSQL 上运行时使用
+//
+//	go test -args -dbDrv=postgres -dbURL="host=127.0.0.1 dbname=tpt_data_test user=xxx password=xxx sslmode=disable"
+var driverName = flag.String("dbDrv", "sqlite3", "")
+var dataSourceName = flag.String("dbURL", "file:tpt_data_test?mode=memory&cache=shared&_foreign_keys=1", "")
+
+// schemaTables 是所有的表, 按删除的顺序排列
+var schemaTables = []string{
+	"tpt_user_roles",
+	"tpt_user_profiles",
+	"tpt_user_state_changes",
+	"tpt_user_password_history",
+	"tpt_user_totp",
+	"tpt_user_recovery_codes",
+	"tpt_user_tokens",
+	"tpt_password_reset_tokens",
+	"tpt_users",
+	"tpt_roles",
+	"tpt_schema_migrations",
+	"tpt_schema_lock",
+}
+
+// dropSchema 删除 store 的所有表
+func dropSchema(db *sql.DB, store *Store) error {
+	for _, table := range schemaTables {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + store.TableName(table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 func dbTest(t *testing.T, cb func(db *sql.DB)) {
+	dialect, ok := DialectByName(*driverName)
+	if !ok {
+		t.Skip("dialect of '" + *driverName + "' is unknown")
+	}
+
 	conn, err := sql.Open(*driverName, *dataSourceName)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	defer conn.Close()
+	if dialect == SQLite {
+		// 内存数据库在多个连接之间共享时容易出现 database table is locked
+		conn.SetMaxOpenConns(1)
+	}
 
-	_, err = conn.Exec(`
-DROP TABLE IF EXISTS tpt_user_roles;
-DROP TABLE IF EXISTS tpt_users;
-DROP TABLE IF EXISTS tpt_roles;
-DROP TABLE IF EXISTS tpt_user_profiles;
-
-CREATE TABLE tpt_roles
-(
-  id serial,
-  name character varying(50),
-  permission_keys character varying(40000),
-  description character varying(200),
-  created_at timestamp with time zone DEFAULT now(),
-  updated_at timestamp with time zone DEFAULT now(),
-  CONSTRAINT tpt_roles_pkey PRIMARY KEY (id),
-  CONSTRAINT tpt_roles_name_uq UNIQUE (name)
-);
-
-CREATE TABLE tpt_users
-(
-  id serial,
-  name character varying(50),
-  password character varying(200),
-  phone character varying(50),
-  email character varying(100),
-  description character varying(200),
-  created_at timestamp with time zone DEFAULT now(),
-  updated_at timestamp with time zone DEFAULT now(),
-  state integer NOT NULL DEFAULT 0,
-  CONSTRAINT tpt_users_pkey PRIMARY KEY (id),
-  CONSTRAINT tpt_users_name_uq UNIQUE (name)
-);
-
-
-CREATE TABLE tpt_user_profiles
-(
-  id serial,
-  usr character varying(50),
-  name character varying(50),
-  value character varying(10000) NOT NULL,
-  created_at timestamp without time zone,
-  updated_at timestamp without time zone,
-  CONSTRAINT tpt_user_profiles_pkey PRIMARY KEY (id)
-);
-
-CREATE TABLE tpt_user_roles
-(
-  id serial,
-  user_id bigint NOT NULL,
-  role_id bigint NOT NULL,
-  created_at timestamp without time zone,
-  updated_at timestamp without time zone,
-  CONSTRAINT tpt_user_roles_pkey PRIMARY KEY (id),
-  CONSTRAINT tpt_user_roles_role_id_fkey FOREIGN KEY (role_id)
-      REFERENCES public.tpt_roles (id) MATCH SIMPLE
-      ON UPDATE NO ACTION ON DELETE CASCADE,
-  CONSTRAINT tpt_user_roles_user_id_fkey FOREIGN KEY (user_id)
-      REFERENCES public.tpt_users (id) MATCH SIMPLE
-      ON UPDATE NO ACTION ON DELETE CASCADE
-);`)
-	if err != nil {
+	store := NewStore(conn, dialect, nil)
+	if err := dropSchema(conn, store); err != nil {
 		t.Error(err)
 		return
 	}
-	PlaceholderFormat = Dollar
-	IsReturning = true
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	PasswordHashCost = bcrypt.MinCost
+
+	// 包级的 Users, Roles 和 UserProfiles 使用 DefaultStore, 测试期间让它使用 store 的方言,
+	// 而不是修改 PlaceholderFormat 和 IsReturning
+	saved := *DefaultStore
+	*DefaultStore = *store
+	defer func() {
+		*DefaultStore = saved
+	}()
 
 	cb(conn)
 }
@@ -217,11 +211,15 @@ func TestUserDao(t *testing.T) {
 		user2.Password = "aaa_pwd"
 		user2.Phone = "23"
 		user2.Email = "a1@h.com"
-		user2.State = 123
+		user2.State = UserLocked
 		if err := user2.UpdateIt(db); err != nil {
 			t.Error(err)
 			return
 		}
+		// UpdateIt 不修改状态
+		if user2.State != user1.State {
+			t.Error("state is changed,", user2.State)
+		}
 
 		user4, err := Users.FindByID(db, id)
 		if err != nil {
@@ -234,6 +232,9 @@ func TestUserDao(t *testing.T) {
 
 func TestUserProfileDao(t *testing.T) {
 	dbTest(t, func(db *sql.DB) {
+		if !createUsers(t, db, "u1", "u3") {
+			return
+		}
 		userProfile1 := &UserProfile{
 			User:  "u1",
 			Name:  "a",
@@ -411,3 +412,77 @@ func TestUserRoleDao(t *testing.T) {
 		t.Log("====", 3, "END")
 	})
 }
+
+func TestQueryUserRBAC(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		role1 := &Role{
+			Name:           "rbac_r1",
+			PermissionKeys: "k1, k2",
+		}
+		role2 := &Role{
+			Name:           "rbac_r2",
+			PermissionKeys: `["k2","k3"]`,
+		}
+		user1 := &User{
+			Name: "rbac1",
+		}
+		for _, role := range []*Role{role1, role2} {
+			if _, err := role.CreateIt(db); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if _, err := user1.CreateIt(db); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, role := range []*Role{role1, role2} {
+			if err := Users.AddRole(db, user1.ID, role.ID); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		rbac, err := QueryUserRBAC(db, user1.Name)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(rbac.Roles) != 2 {
+			t.Error(rbac.Roles)
+		}
+		for _, key := range []string{"k1", "k2", "k3"} {
+			if !rbac.HasPermission(key) {
+				t.Error(key, "isn't found")
+			}
+		}
+		if rbac.HasPermission("k4") {
+			t.Error("k4 is found")
+		}
+		if keys := rbac.PermissionKeys(); len(keys) != 3 {
+			t.Error(keys)
+		}
+	})
+}
+
+func TestContextCanceled(t *testing.T) {
+	dbTest(t, func(db *sql.DB) {
+		user1 := &User{Name: "ctx1"}
+		if _, err := user1.CreateItContext(context.Background(), db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := Users.FindByIDContext(ctx, db, user1.ID); err != context.Canceled {
+			t.Error("want context.Canceled, got", err)
+		}
+		if _, err := QueryUserRBACContext(ctx, db, "ctx1"); err == nil {
+			t.Error("query with a canceled context")
+		}
+		if err := Users.AddRoleContext(ctx, db, user1.ID, 1); err != context.Canceled {
+			t.Error("want context.Canceled, got", err)
+		}
+	})
+}